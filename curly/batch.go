@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// batchItem is URL of batch download with optional output and checksum.
+type batchItem struct {
+	URL      *url.URL
+	Output   string
+	Checksum *checksum
+}
+
+// batchItems returns URLs listed in -input-file followed by URLs given as
+// arguments.
+func batchItems(inputFile string, args []string) ([]batchItem, error) {
+	var items []batchItem
+	if inputFile != "" {
+		var err error
+		if items, err = parseInputFile(inputFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range args {
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("unable parse arg flag: %w", err)
+		}
+		items = append(items, batchItem{URL: u})
+	}
+	return items, nil
+}
+
+// parseInputFile parses -input-file, every line is: URL [OUTPUT|-] [CHECKSUM].
+// Output "-" keeps the default output, empty lines and lines starting with #
+// are skipped.
+func parseInputFile(name string) ([]batchItem, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open input file: %w", err)
+	}
+	defer f.Close()
+
+	var items []batchItem
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected URL [OUTPUT|-] [CHECKSUM], got %d fields", name, line, len(fields))
+		}
+		var item batchItem
+		if item.URL, err = url.Parse(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if len(fields) > 1 && fields[1] != "-" {
+			item.Output = fields[1]
+		}
+		if len(fields) > 2 {
+			if item.Checksum, err = parseChecksum(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+			}
+		}
+		items = append(items, item)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read input file: %w", err)
+	}
+	return items, nil
+}
+
+// jobConfig returns config of i-th batch download. Output of the item is used,
+// otherwise -output is a directory where the file is stored under its remote
+// name. Chunks are written to -output-chunked prefix followed by the name.
+func jobConfig(cfg *Config, item batchItem, i int) (*Config, error) {
+	job := *cfg
+	job.Batch = nil
+	job.DownloadURL = item.URL
+
+	name := path.Base(item.URL.Path)
+	if name == "/" || name == "." {
+		name = fmt.Sprintf("download-%d", i)
+	}
+
+	switch {
+	case item.Output != "":
+		job.Output = item.Output
+	case cfg.Output == "":
+	case cfg.Output == "-":
+		if cfg.Parallel > 1 {
+			return nil, fmt.Errorf("-output=- can not be combined with -parallel")
+		}
+	default:
+		if fi, err := os.Stat(cfg.Output); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("-output %s must be a directory when downloading more URLs", cfg.Output)
+		}
+		job.Output = filepath.Join(cfg.Output, name)
+	}
+
+	if cfg.ChunkedPrefix != "" {
+		job.ChunkedPrefix = cfg.ChunkedPrefix + "." + name
+	}
+
+	switch {
+	case item.Checksum != nil:
+		job.Checksum = item.Checksum
+	case cfg.ChecksumFile != "":
+		names := []string{name}
+		if job.Output != "" && job.Output != "-" {
+			names = append([]string{filepath.Base(job.Output)}, names...)
+		}
+		var err error
+		if job.Checksum, err = lookupChecksumFile(cfg.ChecksumFile, cfg.Hashes, names...); err != nil {
+			return nil, err
+		}
+	default:
+		// -checksum is rejected for batch downloads, content of every URL
+		// differs
+		job.Checksum = nil
+	}
+
+	if job.Continue && (job.Output == "" || job.Output == "-") {
+		return nil, fmt.Errorf("-continue requires -output file for %s", item.URL)
+	}
+
+	// progress bars of parallel downloads would overwrite each other
+	if cfg.Parallel > 1 && job.Progress == progressBar {
+		job.Progress = progressNone
+	}
+	return &job, nil
+}
+
+type batchResult struct {
+	URL      string
+	Output   string
+	Size     int64
+	Duration time.Duration
+	Err      error
+}
+
+// runBatch downloads all cfg.Batch URLs, at most cfg.Parallel at once, and
+// prints summary into stderr. It fails if any download failed.
+func runBatch(log *zap.SugaredLogger, c *http.Client, cfg *Config) error {
+	jobs := make([]*Config, len(cfg.Batch))
+	outputs := make(map[string]string)
+	for i, item := range cfg.Batch {
+		job, err := jobConfig(cfg, item, i)
+		if err != nil {
+			return err
+		}
+		for _, out := range []string{job.Output, job.ChunkedPrefix} {
+			if out == "" || out == "-" {
+				continue
+			}
+			if prev, ok := outputs[out]; ok {
+				return fmt.Errorf("%s and %s are both written to %s", prev, item.URL, out)
+			}
+			outputs[out] = item.URL.String()
+		}
+		jobs[i] = job
+	}
+
+	results := make([]batchResult, len(jobs))
+	sem := make(chan struct{}, cfg.Parallel)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, job *Config) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			n, err := download(log, c, job)
+			if err != nil {
+				log.Errorf("download of %s failed: %v", job.DownloadURL, err)
+			}
+			results[i] = batchResult{
+				URL:      job.DownloadURL.String(),
+				Output:   job.Output,
+				Size:     n,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	if err := printSummary(os.Stderr, results); err != nil {
+		return err
+	}
+	return batchError(results)
+}
+
+func printSummary(w io.Writer, results []batchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tSTATUS\tSIZE\tTIME\tOUTPUT")
+	for _, r := range results {
+		status, size, output := "ok", formatBytes(r.Size), r.Output
+		if r.Err != nil {
+			status, size, output = "FAILED", "-", r.Err.Error()
+		}
+		if output == "" {
+			output = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.URL, status, size, r.Duration.Round(time.Millisecond), output)
+	}
+	return tw.Flush()
+}
+
+// batchError returns error if any download failed, it wraps
+// ErrChecksumMismatch when all failures are checksum mismatches.
+func batchError(results []batchResult) error {
+	var failed, mismatched int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			if errors.Is(r.Err, ErrChecksumMismatch) {
+				mismatched++
+			}
+		}
+	}
+	switch {
+	case failed == 0:
+		return nil
+	case failed == mismatched:
+		return fmt.Errorf("%d of %d downloads failed: %w", failed, len(results), ErrChecksumMismatch)
+	default:
+		return fmt.Errorf("%d of %d downloads failed", failed, len(results))
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBatchItems(t *testing.T) {
+	input := "# mirrors\n" +
+		"\n" +
+		"http://example.com/a.bin\n" +
+		"http://example.com/b.bin  b.out\n" +
+		"http://example.com/c.bin  -  sha256:" + sha256Of123456789 + "\n"
+	fname := filepath.Join(t.TempDir(), "urls.txt")
+	require.NoError(t, os.WriteFile(fname, []byte(input), 0o644))
+
+	items, err := batchItems(fname, []string{"http://example.com/d.bin"})
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+
+	assert.Equal(t, "http://example.com/a.bin", items[0].URL.String())
+	assert.Equal(t, "b.out", items[1].Output)
+	assert.Empty(t, items[2].Output)
+	assert.Equal(t, &checksum{Hash: "sha256", Sum: sha256Of123456789}, items[2].Checksum)
+	assert.Equal(t, "http://example.com/d.bin", items[3].URL.String())
+
+	require.NoError(t, os.WriteFile(fname, []byte("http://example.com/a.bin a sha256:00 extra\n"), 0o644))
+	_, err = batchItems(fname, nil)
+	assert.Error(t, err)
+}
+
+func TestJobConfig(t *testing.T) {
+	dir := t.TempDir()
+	u, err := url.Parse("http://example.com/files/a.bin")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		item       batchItem
+		wantOutput string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{
+			name:       "output directory",
+			cfg:        Config{Output: dir, ChunkedPrefix: "chunks", Parallel: 2},
+			item:       batchItem{URL: u},
+			wantOutput: filepath.Join(dir, "a.bin"),
+			wantPrefix: "chunks.a.bin",
+		},
+		{
+			name:       "output of item",
+			cfg:        Config{Output: dir, Parallel: 1},
+			item:       batchItem{URL: u, Output: "custom"},
+			wantOutput: "custom",
+		},
+		{
+			name:    "output file",
+			cfg:     Config{Output: filepath.Join(dir, "missing"), Parallel: 1},
+			item:    batchItem{URL: u},
+			wantErr: true,
+		},
+		{
+			name:       "stdout",
+			cfg:        Config{Output: "-", Parallel: 1},
+			item:       batchItem{URL: u},
+			wantOutput: "-",
+		},
+		{
+			name:    "parallel stdout",
+			cfg:     Config{Output: "-", Parallel: 2},
+			item:    batchItem{URL: u},
+			wantErr: true,
+		},
+		{
+			name: "global checksum is not inherited",
+			cfg:  Config{Checksum: &checksum{Hash: "sha256", Sum: sha256Of123456789}, Parallel: 1},
+			item: batchItem{URL: u},
+		},
+		{
+			name:    "continue without output",
+			cfg:     Config{Continue: true, Parallel: 1},
+			item:    batchItem{URL: u},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := jobConfig(&tt.cfg, tt.item, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, u, job.DownloadURL)
+			assert.Equal(t, tt.wantOutput, job.Output)
+			assert.Equal(t, tt.wantPrefix, job.ChunkedPrefix)
+			assert.Equal(t, tt.item.Checksum, job.Checksum)
+		})
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("123456789"+r.URL.Path))
+	}))
+	defer ts.Close()
+
+	var items []batchItem
+	for _, p := range []string{"/a", "/b", "/c"} {
+		u, err := url.Parse(ts.URL + p)
+		require.NoError(t, err)
+		items = append(items, batchItem{URL: u})
+	}
+	dir := t.TempDir()
+	cfg := &Config{
+		Output:   dir,
+		Progress: progressNone,
+		Parallel: 2,
+		Batch:    items,
+	}
+
+	require.NoError(t, runBatch(zap.NewNop().Sugar(), ts.Client(), cfg))
+	for _, p := range []string{"a", "b", "c"} {
+		b, err := os.ReadFile(filepath.Join(dir, p))
+		require.NoError(t, err)
+		assert.Equal(t, "123456789/"+p, string(b))
+	}
+
+	// checksum mismatch and unreachable server
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	items[0].Checksum = &checksum{Hash: "sha256", Sum: sha256Of123456789}
+	items[1].URL, _ = url.Parse(closed.URL + "/b")
+	err := runBatch(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 3 downloads failed")
+	assert.False(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestRunBatch_DuplicateOutput(t *testing.T) {
+	var items []batchItem
+	for _, s := range []string{"http://example.com/a/file", "http://example.com/b/file"} {
+		u, err := url.Parse(s)
+		require.NoError(t, err)
+		items = append(items, batchItem{URL: u})
+	}
+	cfg := &Config{Output: t.TempDir(), Parallel: 1, Batch: items}
+	err := runBatch(zap.NewNop().Sugar(), http.DefaultClient, cfg)
+	assert.Error(t, err)
+}
+
+func TestPrintSummary(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, printSummary(&buf, []batchResult{
+		{URL: "http://example.com/a", Output: "a", Size: 2048, Duration: time.Second},
+		{URL: "http://example.com/b", Err: io.ErrUnexpectedEOF},
+	}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"URL", "STATUS", "SIZE", "TIME", "OUTPUT"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"http://example.com/a", "ok", "2.0", "KiB", "1s", "a"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"http://example.com/b", "FAILED", "-", "0s", "unexpected", "EOF"}, strings.Fields(lines[2]))
+
+	err := batchError([]batchResult{{}, {Err: ErrChecksumMismatch}})
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.EqualError(t, err, "1 of 2 downloads failed: "+ErrChecksumMismatch.Error())
+}
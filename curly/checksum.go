@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when downloaded content does not match
+// expected -checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const partialSuffix = ".partial"
+
+// checksum is expected digest of downloaded content.
+type checksum struct {
+	Hash string
+	Sum  string
+}
+
+// parseChecksum parses "<hash>:<hex>" value, e.g. "sha256:2c26b46b...".
+func parseChecksum(s string) (*checksum, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("checksum %q must be in format <hash>:<hex>", s)
+	}
+	return newChecksum(strings.ToLower(s[:i]), s[i+1:])
+}
+
+func newChecksum(hash, sum string) (*checksum, error) {
+	if _, ok := hashAlgorithms[hash]; !ok {
+		return nil, fmt.Errorf("unsupported hash %q, supported: %s", hash, strings.Join(supportedHashes(), ","))
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil {
+		return nil, fmt.Errorf("invalid %s checksum %q: %w", hash, sum, err)
+	}
+	return &checksum{Hash: hash, Sum: sum}, nil
+}
+
+// hashBySize guesses hash algorithm of untagged SHA256SUMS like files from
+// length of hex digest. 128 characters long digest is sha512 or blake2b.
+var hashBySize = map[int][]string{
+	8:   {"crc32c"},
+	32:  {"md5"},
+	40:  {"sha1"},
+	64:  {"sha256"},
+	128: {"sha512", "blake2b"},
+}
+
+// untaggedHash returns hash algorithm of untagged digest sum. Ambiguous length
+// is resolved by hashes selected by -hash.
+func untaggedHash(sum string, hashes []string) (string, error) {
+	candidates := hashBySize[len(sum)]
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	var found []string
+	for _, c := range candidates {
+		for _, h := range hashes {
+			if c == h {
+				found = append(found, c)
+			}
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(candidates) == 0:
+		return "", fmt.Errorf("unknown hash of %d characters long checksum %q", len(sum), sum)
+	default:
+		return "", fmt.Errorf("checksum %q may be %s, use tagged format or select one by -hash", sum, strings.Join(candidates, " or "))
+	}
+}
+
+// lookupChecksumFile finds checksum of one of names in file produced by
+// sha256sum and similar tools. Both default "<hex>  name" and tagged
+// "SHA256 (name) = <hex>" formats are supported, hash of untagged checksum
+// with ambiguous length is one of hashes.
+func lookupChecksumFile(fname string, hashes []string, names ...string) (*checksum, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open checksum file: %w", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var hash, sum, name string
+		if i := strings.Index(line, ") = "); i > 0 && strings.Contains(line[:i], " (") {
+			// tagged format
+			j := strings.Index(line, " (")
+			hash, name, sum = strings.ToLower(line[:j]), line[j+2:i], line[i+4:]
+		} else {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			// binary mode is marked by '*' before file name
+			sum, name = fields[0], strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		}
+
+		for _, n := range names {
+			if n != "" && (name == n || path.Base(filepath.ToSlash(name)) == n) {
+				if hash == "" {
+					var err error
+					if hash, err = untaggedHash(sum, hashes); err != nil {
+						return nil, fmt.Errorf("checksum of %s in %s: %w", name, fname, err)
+					}
+				}
+				return newChecksum(hash, sum)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read checksum file: %w", err)
+	}
+	return nil, fmt.Errorf("checksum of %s not found in %s", strings.Join(names, " or "), fname)
+}
+
+// verify compares expected checksum with digest computed by d.
+func (c *checksum) verify(d *digests) error {
+	got, ok := d.Sum(c.Hash)
+	if !ok {
+		return fmt.Errorf("%s digest was not computed", c.Hash)
+	}
+	if got != c.Sum {
+		return fmt.Errorf("%w: %s expected %s, got %s", ErrChecksumMismatch, c.Hash, c.Sum, got)
+	}
+	return nil
+}
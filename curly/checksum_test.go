@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sha256Of123456789 = "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225"
+	md5Of123456789    = "25f9e794323b453885f5181f1b624d0b"
+)
+
+func TestChecksum_Verify(t *testing.T) {
+	tests := []struct {
+		name      string
+		checksum  string
+		wantErr   bool
+		wantMatch bool
+	}{
+		{name: "match", checksum: "sha256:" + sha256Of123456789, wantMatch: true},
+		{name: "match upper case", checksum: "SHA256:15E2B0D3C33891EBB0F1EF609EC419420C20E320CE94C65FBC8C3312448EB225", wantMatch: true},
+		{name: "mismatch", checksum: "md5:00000000000000000000000000000000"},
+		{name: "missing hash", checksum: sha256Of123456789, wantErr: true},
+		{name: "unsupported hash", checksum: "whirlpool:00", wantErr: true},
+		{name: "invalid hex", checksum: "sha256:xyz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseChecksum(tt.checksum)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			d := newDigests([]string{c.Hash})
+			_, err = io.WriteString(d, "123456789")
+			require.NoError(t, err)
+
+			err = c.verify(d)
+			if tt.wantMatch {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		})
+	}
+}
+
+func TestLookupChecksumFile(t *testing.T) {
+	sums := "# release sums\n" +
+		"0000000000000000000000000000000000000000000000000000000000000000  other.tar.gz\n" +
+		sha256Of123456789 + " *dist/file.tar.gz\n" +
+		"MD5 (tagged.bin) = " + md5Of123456789 + "\n" +
+		strings.Repeat("ab", 64) + "  long.bin\n"
+	fname := filepath.Join(t.TempDir(), "SHA256SUMS")
+	require.NoError(t, os.WriteFile(fname, []byte(sums), 0o644))
+
+	tests := []struct {
+		name    string
+		names   []string
+		hashes  []string
+		want    *checksum
+		wantErr bool
+	}{
+		{
+			name:  "untagged binary mode",
+			names: []string{"output", "file.tar.gz"},
+			want:  &checksum{Hash: "sha256", Sum: sha256Of123456789},
+		},
+		{
+			name:  "tagged",
+			names: []string{"tagged.bin"},
+			want:  &checksum{Hash: "md5", Sum: md5Of123456789},
+		},
+		{
+			name:    "ambiguous length",
+			names:   []string{"long.bin"},
+			hashes:  []string{"md5"},
+			wantErr: true,
+		},
+		{
+			name:   "ambiguous length selected by hash",
+			names:  []string{"long.bin"},
+			hashes: []string{"md5", "blake2b"},
+			want:   &checksum{Hash: "blake2b", Sum: strings.Repeat("ab", 64)},
+		},
+		{
+			name:    "not found",
+			names:   []string{"missing.bin"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupChecksumFile(fname, tt.hashes, tt.names...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -10,29 +10,63 @@ var _ io.Writer = (*Chunked)(nil)
 
 type Chunked struct {
 	chunker Chunker
-	size    int
-	maxSize int
+	size    int64
+	maxSize int64
+	// larger is number of next chunks which are one byte larger than
+	// maxSize, it spreads remainder of content split into even chunks.
+	larger int64
 }
 
-func NewChunked(chunker Chunker, chunkSize int) io.Writer {
+// NewChunked returns writer which splits content into chunks of chunkSize
+// bytes, chunkSize must be positive.
+func NewChunked(chunker Chunker, chunkSize int64) (io.Writer, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d: chunk size must be positive", chunkSize)
+	}
 	return &Chunked{
 		chunker: chunker,
 		size:    0,
 		maxSize: chunkSize,
+	}, nil
+}
+
+// NewEvenChunked returns writer which splits content of size bytes into n
+// chunks, sizes of the chunks differ by one byte at most. Content shorter
+// than n bytes is split into one byte chunks.
+func NewEvenChunked(chunker Chunker, size int64, n int) (io.Writer, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of chunks %d: it must be positive", n)
+	}
+	if size < int64(n) {
+		n = int(size)
+	}
+	if n == 0 {
+		return &Chunked{chunker: chunker, maxSize: 1}, nil
 	}
+	return &Chunked{
+		chunker: chunker,
+		maxSize: size / int64(n),
+		larger:  size % int64(n),
+	}, nil
 }
 
 func (c *Chunked) Write(p []byte) (int, error) {
-	if len(p) < c.maxSize-c.size {
+	maxSize := c.maxSize
+	if c.larger > 0 {
+		maxSize++
+	}
+	// new chunk is created when there is more content to write, so content
+	// of exactly chunk size does not end with empty chunk
+	if int64(len(p)) <= maxSize-c.size {
 		n, err := c.chunker.Write(p)
 		if err != nil {
 			return 0, fmt.Errorf("chunked.Write: %w", err)
 		}
-		c.size += n
+		c.size += int64(n)
 		return n, nil
 	}
 
-	off := c.maxSize - c.size
+	off := maxSize - c.size
 	n, err := c.chunker.Write(p[:off])
 	if err != nil {
 		return n, fmt.Errorf("chunked.Write offset: %w", err)
@@ -42,27 +76,53 @@ func (c *Chunked) Write(p []byte) (int, error) {
 		return n, fmt.Errorf("chunked.NewChunk: %w", err)
 	}
 	c.size = 0
-	return c.Write(p[off:])
+	if c.larger > 0 {
+		c.larger--
+	}
+	m, err := c.Write(p[off:])
+	return n + m, err
+}
+
+// chunkSizeFor returns size of the largest chunk of content of given size
+// split by NewEvenChunked into n chunks.
+func chunkSizeFor(size int64, n int) int64 {
+	chunkSize := (size + int64(n) - 1) / int64(n)
+	if chunkSize < 1 {
+		return 1
+	}
+	return chunkSize
 }
 
+// Chunker writes content into separate chunks. Chunked calls NewChunk on every
+// chunk boundary, so implementation knows where one chunk ends and next starts.
 type Chunker interface {
 	io.WriteCloser
 	NewChunk() error
+	// Name returns name of the chunk which is currently written.
+	Name() string
 }
 
 type fileChunker struct {
 	file   *os.File
 	prefix string
+	ext    string
 	idx    int
 }
 
 func NewFileChunker(prefix string) (Chunker, error) {
+	return newFileChunker(prefix, "")
+}
+
+// newFileChunker creates chunks FILEPREFIX.N with file extension ext,
+// e.g. ".gz" creates FILEPREFIX.0.gz.
+func newFileChunker(prefix string, ext string) (*fileChunker, error) {
 	chunker := fileChunker{
 		prefix: prefix,
+		ext:    ext,
 		idx:    0,
 	}
 	var err error
-	chunker.file, err = os.Create(filename(chunker.prefix, chunker.idx))
+	chunker.file, err = os.Create(chunker.Name())
 	if err != nil {
 		return nil, fmt.Errorf("NewFileChunker unable to create file %w", err)
 	}
@@ -75,13 +135,17 @@ func (f *fileChunker) NewChunk() error {
 	if err = f.file.Close(); err != nil {
 		return fmt.Errorf("NewChunk close: %w", err)
 	}
-	f.file, err = os.Create(filename(f.prefix, f.idx))
+	f.file, err = os.Create(f.Name())
 	if err != nil {
 		return fmt.Errorf("NewChunk unable to create file %w", err)
 	}
 	return nil
 }
 
+func (f *fileChunker) Name() string {
+	return filename(f.prefix, f.idx) + f.ext
+}
+
 func (f *fileChunker) Write(p []byte) (int, error) {
 	return f.file.Write(p)
 }
@@ -90,6 +154,23 @@ func (f *fileChunker) Close() error {
 	return f.file.Close()
 }
 
+// validateChunks checks -chunks, it can not be combined with -chunk-size.
+func validateChunks(cfg *Config, chunkSizeSet bool) error {
+	switch {
+	case cfg.Chunks < 0:
+		return fmt.Errorf("-chunks must be positive, got %d", cfg.Chunks)
+	case cfg.Chunks == 0:
+		return nil
+	case cfg.ChunkedPrefix == "":
+		return fmt.Errorf("-chunks requires -output-chunked")
+	case chunkSizeSet:
+		return fmt.Errorf("-chunks can not be combined with -chunk-size")
+	case cfg.CompressedChunkSize:
+		return fmt.Errorf("-chunk-size-compressed can not be combined with -chunks")
+	}
+	return nil
+}
+
 func filename(prefix string, idx int) string {
 	return fmt.Sprintf("%s.%d", prefix, idx)
 }
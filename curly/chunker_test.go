@@ -2,10 +2,20 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 var _ Chunker = &testChunked{}
@@ -33,6 +43,10 @@ func (c *testChunked) Write(p []byte) (n int, err error) {
 	return c.buf.Write(p)
 }
 
+func (c *testChunked) Name() string {
+	return fmt.Sprintf("test.%d", len(c.multiBuffer)-1)
+}
+
 func (c *testChunked) NewChunk() error {
 	var buf bytes.Buffer
 	c.buf = &buf
@@ -40,10 +54,46 @@ func (c *testChunked) NewChunk() error {
 	return nil
 }
 
+func TestNewChunked_InvalidSize(t *testing.T) {
+	for _, size := range []int64{0, -1} {
+		tChunker := NewTestChunked()
+		_, err := NewChunked(tChunker, size)
+		assert.Error(t, err)
+		assert.Len(t, tChunker.multiBuffer, 1)
+		assert.Zero(t, tChunker.buf.Len())
+	}
+}
+
+func TestOpenChunked_InvalidSize(t *testing.T) {
+	u, err := url.Parse("http://example.com/foo")
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "zero", cfg: Config{ChunkSize: 0}},
+		{name: "negative", cfg: Config{ChunkSize: -1}},
+		{name: "compressed too small", cfg: Config{ChunkSize: 10, ChunkCompress: "gzip", CompressedChunkSize: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := tt.cfg
+			cfg.DownloadURL = u
+			cfg.ChunkedPrefix = filepath.Join(dir, "foo")
+			_, _, err := openChunked(&cfg, 100)
+			assert.Error(t, err)
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
+
 func TestChunked_Write(t *testing.T) {
 	tests := []struct {
 		name    string
-		maxSize int
+		maxSize int64
 		input   [][]byte
 		want    [][]byte
 	}{
@@ -76,6 +126,17 @@ func TestChunked_Write(t *testing.T) {
 				[]byte("23"),
 			},
 		},
+		{
+			name:    "exact multiple of max size",
+			maxSize: 3,
+			input: [][]byte{
+				[]byte("123456"),
+			},
+			want: [][]byte{
+				[]byte("123"),
+				[]byte("456"),
+			},
+		},
 		{
 			name:    "max size 3",
 			maxSize: 3,
@@ -95,12 +156,15 @@ func TestChunked_Write(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			tChunker := NewTestChunked()
 			defer tChunker.Close()
-			c := NewChunked(tChunker, tt.maxSize)
+			c, err := NewChunked(tChunker, tt.maxSize)
+			assert.NoError(t, err)
 
 			for _, p := range tt.input {
-				_, err := c.Write(p)
+				n, err := c.Write(p)
 				assert.NoError(t, err)
+				assert.Equal(t, len(p), n)
 			}
+			assert.Len(t, tChunker.multiBuffer, len(tt.want))
 
 			for i := range tt.want {
 				diff := cmp.Diff(tt.want[i], tChunker.multiBuffer[i].Bytes())
@@ -109,3 +173,140 @@ func TestChunked_Write(t *testing.T) {
 		})
 	}
 }
+
+func TestChunkSizeFor(t *testing.T) {
+	tests := []struct {
+		size int64
+		n    int
+		want int64
+	}{
+		{size: 100, n: 4, want: 25},
+		{size: 101, n: 4, want: 26},
+		{size: 3, n: 8, want: 1},
+		{size: 0, n: 8, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.size, tt.n), func(t *testing.T) {
+			assert.Equal(t, tt.want, chunkSizeFor(tt.size, tt.n))
+		})
+	}
+}
+
+func TestNewEvenChunked(t *testing.T) {
+	tests := []struct {
+		size int64
+		n    int
+		want []int
+	}{
+		{size: 20, n: 8, want: []int{3, 3, 3, 3, 2, 2, 2, 2}},
+		{size: 9, n: 8, want: []int{2, 1, 1, 1, 1, 1, 1, 1}},
+		{size: 16, n: 8, want: []int{2, 2, 2, 2, 2, 2, 2, 2}},
+		{size: 3, n: 8, want: []int{1, 1, 1}},
+		{size: 0, n: 8, want: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.size, tt.n), func(t *testing.T) {
+			tChunker := NewTestChunked()
+			chunked, err := NewEvenChunked(tChunker, tt.size, tt.n)
+			assert.NoError(t, err)
+			content := bytes.Repeat([]byte("x"), int(tt.size))
+			// small writes cross chunk boundaries
+			for len(content) > 0 {
+				n := 5
+				if n > len(content) {
+					n = len(content)
+				}
+				_, err := chunked.Write(content[:n])
+				assert.NoError(t, err)
+				content = content[n:]
+			}
+
+			var got []int
+			for _, buf := range tChunker.multiBuffer {
+				got = append(got, buf.Len())
+			}
+			assert.Equal(t, tt.want, got)
+			if tt.size > 0 {
+				assert.Equal(t, int64(tt.want[0]), chunkSizeFor(tt.size, tt.n))
+			}
+		})
+	}
+
+	_, err := NewEvenChunked(NewTestChunked(), 10, 0)
+	assert.Error(t, err)
+}
+
+func TestValidateChunks(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		chunkSizeSet bool
+		wantErr      string
+	}{
+		{name: "no chunks", cfg: Config{}},
+		{name: "chunks", cfg: Config{Chunks: 8, ChunkedPrefix: "foo"}},
+		{name: "negative", cfg: Config{Chunks: -1, ChunkedPrefix: "foo"}, wantErr: "-chunks must be positive, got -1"},
+		{name: "no prefix", cfg: Config{Chunks: 8}, wantErr: "-chunks requires -output-chunked"},
+		{name: "chunk size", cfg: Config{Chunks: 8, ChunkedPrefix: "foo"}, chunkSizeSet: true, wantErr: "-chunks can not be combined with -chunk-size"},
+		{name: "compressed chunk size", cfg: Config{Chunks: 8, ChunkedPrefix: "foo", CompressedChunkSize: true}, wantErr: "-chunk-size-compressed can not be combined with -chunks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChunks(&tt.cfg, tt.chunkSizeSet)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSpool(t *testing.T) {
+	body, size, err := spool(io.NopCloser(strings.NewReader("spooled content")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("spooled content")), size)
+	name := body.(readCloser).Reader.(*os.File).Name()
+
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "spooled content", string(got))
+	require.NoError(t, body.Close())
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err), err)
+}
+
+func TestDownload_ChunksUnknownLength(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// flushed response has no Content-Length, so it is spooled
+		w.Write([]byte(strings.Repeat("x", 10)))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("y", 10)))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/file")
+	require.NoError(t, err)
+	prefix := filepath.Join(t.TempDir(), "foo")
+	cfg := &Config{
+		DownloadURL:   u,
+		Method:        http.MethodGet,
+		ChunkedPrefix: prefix,
+		Chunks:        8,
+		Std:           stdnull,
+		Progress:      progressNone,
+	}
+	n, err := download(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+
+	var sizes []int64
+	for i := 0; ; i++ {
+		fi, err := os.Stat(filename(prefix, i))
+		if os.IsNotExist(err) {
+			break
+		}
+		require.NoError(t, err)
+		sizes = append(sizes, fi.Size())
+	}
+	assert.Equal(t, []int64{3, 3, 3, 3, 2, 2, 2, 2}, sizes)
+}
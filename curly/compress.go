@@ -0,0 +1,271 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"go.uber.org/multierr"
+)
+
+// compressExt maps -chunk-compress algorithm to file extension of chunks.
+var compressExt = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+	"xz":   ".xz",
+}
+
+func supportedCompressions() []string {
+	names := make([]string, 0, len(compressExt))
+	for name := range compressExt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compressor is a compressing writer, Flush writes all compressed data
+// into the underlying writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newCompressor(algo string, w io.Writer) (compressor, error) {
+	switch algo {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case "xz":
+		return &xzCompressor{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", algo)
+	}
+}
+
+func newDecompressor(algo string, r io.Reader) (io.ReadCloser, error) {
+	switch algo {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{Reader: d, Closer: closerFunc(func() error {
+			d.Close()
+			return nil
+		})}, nil
+	case "xz":
+		d, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(d), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", algo)
+	}
+}
+
+var _ compressor = (*xzCompressor)(nil)
+
+// xzCompressor implements Flush by closing current xz stream, next Write starts
+// a new stream. Concatenated streams are valid xz file.
+type xzCompressor struct {
+	w       io.Writer
+	xw      *xz.Writer
+	written bool
+}
+
+func (x *xzCompressor) Write(p []byte) (int, error) {
+	if x.xw == nil {
+		var err error
+		if x.xw, err = xz.NewWriter(x.w); err != nil {
+			return 0, err
+		}
+		x.written = true
+	}
+	return x.xw.Write(p)
+}
+
+func (x *xzCompressor) Flush() error {
+	if x.xw == nil {
+		return nil
+	}
+	err := x.xw.Close()
+	x.xw = nil
+	return err
+}
+
+// Close writes empty stream when nothing was written, so the output is
+// always valid xz file.
+func (x *xzCompressor) Close() error {
+	if !x.written {
+		if _, err := x.Write(nil); err != nil {
+			return err
+		}
+	}
+	return x.Flush()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var _ Chunker = (*compressChunker)(nil)
+
+// compressChunker compresses every chunk separately so each chunk can be
+// decompressed on its own.
+type compressChunker struct {
+	Chunker
+	algo    string
+	counter *countingWriter
+	cw      compressor
+}
+
+// newCompressChunker compresses chunks written into c, algo must be one of
+// supportedCompressions. File names of chunks should have compressExt extension.
+func newCompressChunker(c Chunker, algo string) (*compressChunker, error) {
+	if _, ok := compressExt[algo]; !ok {
+		return nil, fmt.Errorf("unsupported compression %q", algo)
+	}
+	cc := &compressChunker{
+		Chunker: c,
+		algo:    algo,
+	}
+	if err := cc.start(); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (c *compressChunker) start() error {
+	c.counter = &countingWriter{w: c.Chunker}
+	var err error
+	c.cw, err = newCompressor(c.algo, c.counter)
+	return err
+}
+
+func (c *compressChunker) Write(p []byte) (int, error) {
+	return c.cw.Write(p)
+}
+
+func (c *compressChunker) Flush() error {
+	return c.cw.Flush()
+}
+
+// Compressed returns number of compressed bytes flushed into current chunk.
+func (c *compressChunker) Compressed() int64 {
+	return c.counter.n
+}
+
+func (c *compressChunker) NewChunk() error {
+	if err := c.cw.Close(); err != nil {
+		return fmt.Errorf("unable to close compressor: %w", err)
+	}
+	if err := c.Chunker.NewChunk(); err != nil {
+		return err
+	}
+	return c.start()
+}
+
+func (c *compressChunker) Close() error {
+	return multierr.Combine(c.cw.Close(), c.Chunker.Close())
+}
+
+const (
+	// compressedOverhead is upper bound of bytes added by compressor to a
+	// flushed block of incompressible data on top of 1/16 of its size (stored
+	// blocks, frame and stream headers) or by closing the compressor.
+	compressedOverhead = 256
+	maxCompressedBlock = 1 << 20
+	minCompressedBlock = 1 << 10
+	// minCompressedChunkSize is smallest -chunk-size allowed when chunk size
+	// applies to compressed chunks.
+	minCompressedChunkSize = 16 << 10
+)
+
+// compressedBlockSize returns the largest number of bytes which can be written
+// and flushed into a chunk with space bytes left, so even incompressible data
+// and the compressor trailer surely fit.
+func compressedBlockSize(space int64) int64 {
+	n := (space - 2*compressedOverhead) * 16 / 17
+	if n > maxCompressedBlock {
+		return maxCompressedBlock
+	}
+	return n
+}
+
+var _ io.Writer = (*compressedChunked)(nil)
+
+// compressedChunked splits content so every compressed chunk has at most
+// maxSize bytes. Content is written in blocks, size of a block is chosen so it
+// surely fits into the rest of the chunk. Compressor is flushed after every
+// block, so size of compressed chunk is known before next block starts.
+type compressedChunked struct {
+	chunker    Chunker
+	compressed *compressChunker
+	maxSize    int64
+	block      int64
+	pending    int64
+}
+
+// newCompressedChunked returns writer which writes into chunker, compressed
+// must be the compressChunker which chunker writes to.
+func newCompressedChunked(chunker Chunker, compressed *compressChunker, maxSize int64) (io.Writer, error) {
+	if maxSize < minCompressedChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d: compressed chunk size must be at least %d", maxSize, minCompressedChunkSize)
+	}
+	return &compressedChunked{
+		chunker:    chunker,
+		compressed: compressed,
+		maxSize:    maxSize,
+	}, nil
+}
+
+func (c *compressedChunked) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if c.block == 0 {
+			c.block = compressedBlockSize(c.maxSize - c.compressed.Compressed())
+			if c.block < minCompressedBlock {
+				c.block = 0
+				if err := c.chunker.NewChunk(); err != nil {
+					return written, fmt.Errorf("compressedChunked.NewChunk: %w", err)
+				}
+				continue
+			}
+		}
+
+		n := c.block - c.pending
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		m, err := c.chunker.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, fmt.Errorf("compressedChunked.Write: %w", err)
+		}
+		c.pending += n
+		p = p[n:]
+
+		if c.pending == c.block {
+			if err := c.compressed.Flush(); err != nil {
+				return written, fmt.Errorf("compressedChunked.Flush: %w", err)
+			}
+			c.block, c.pending = 0, 0
+		}
+	}
+	return written, nil
+}
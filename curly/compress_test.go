@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCompressor(t *testing.T) {
+	content := bytes.Repeat([]byte("hello compressor "), 1000)
+	for _, algo := range supportedCompressions() {
+		t.Run(algo, func(t *testing.T) {
+			var buf bytes.Buffer
+			c, err := newCompressor(algo, &buf)
+			require.NoError(t, err)
+			// flushed blocks must be decompressed as one stream
+			_, err = c.Write(content[:100])
+			require.NoError(t, err)
+			require.NoError(t, c.Flush())
+			_, err = c.Write(content[100:])
+			require.NoError(t, err)
+			require.NoError(t, c.Close())
+			assert.Less(t, buf.Len(), len(content))
+
+			d, err := newDecompressor(algo, &buf)
+			require.NoError(t, err)
+			defer d.Close()
+			got, err := io.ReadAll(d)
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+		})
+	}
+}
+
+func TestCompressedChunks(t *testing.T) {
+	random := make([]byte, 300_000)
+	rand.New(rand.NewSource(1)).Read(random)
+	compressible := bytes.Repeat([]byte("0123456789"), 100_000)
+
+	tests := []struct {
+		name             string
+		content          []byte
+		compressedTarget bool
+	}{
+		{name: "raw target", content: compressible},
+		{name: "compressed target random", content: random, compressedTarget: true},
+		{name: "compressed target compressible", content: compressible, compressedTarget: true},
+		{name: "empty", content: nil},
+	}
+	const chunkSize = 64 << 10
+	for _, algo := range supportedCompressions() {
+		for _, tt := range tests {
+			t.Run(algo+" "+tt.name, func(t *testing.T) {
+				prefix := filepath.Join(t.TempDir(), "foo")
+				fc, err := newFileChunker(prefix, compressExt[algo])
+				require.NoError(t, err)
+				cc, err := newCompressChunker(fc, algo)
+				require.NoError(t, err)
+				mc := newManifestChunker(cc, "http://example.com/foo", chunkSize)
+				mc.Manifest().Compression = algo
+
+				var chunked io.Writer
+				if tt.compressedTarget {
+					chunked, err = newCompressedChunked(mc, cc, chunkSize)
+				} else {
+					chunked, err = NewChunked(mc, chunkSize)
+				}
+				require.NoError(t, err)
+
+				// write in pieces same as io.Copy does
+				_, err = io.CopyBuffer(chunked, bytes.NewReader(tt.content), make([]byte, 32<<10))
+				require.NoError(t, err)
+				require.NoError(t, mc.Close())
+				require.NoError(t, mc.Manifest().save(manifestFilename(prefix)))
+
+				for _, c := range mc.Manifest().Chunks {
+					fi, err := os.Stat(filepath.Join(filepath.Dir(prefix), c.Name))
+					require.NoError(t, err)
+					if tt.compressedTarget {
+						assert.LessOrEqual(t, fi.Size(), int64(chunkSize))
+					} else {
+						assert.LessOrEqual(t, c.Size, int64(chunkSize))
+					}
+				}
+
+				for _, args := range [][]string{
+					{prefix},
+					// join without manifest detects compression from file names
+					{"-chunk-size", "64KiB", prefix},
+				} {
+					if len(args) > 1 {
+						require.NoError(t, os.Remove(manifestFilename(prefix)))
+					}
+					output := filepath.Join(t.TempDir(), "joined")
+					err = runJoin(zap.NewNop().Sugar(), append([]string{"-output", output}, args...))
+					require.NoError(t, err)
+					got, err := os.ReadFile(output)
+					require.NoError(t, err)
+					assert.True(t, bytes.Equal(tt.content, got))
+				}
+			})
+		}
+	}
+}
+
+func TestCompressedChunks_Corrupted(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "foo")
+	fc, err := newFileChunker(prefix, ".gz")
+	require.NoError(t, err)
+	cc, err := newCompressChunker(fc, "gzip")
+	require.NoError(t, err)
+	mc := newManifestChunker(cc, "http://example.com/foo", 1000)
+	mc.Manifest().Compression = "gzip"
+	chunked, err := NewChunked(mc, 1000)
+	require.NoError(t, err)
+	_, err = chunked.Write(bytes.Repeat([]byte("a"), 2500))
+	require.NoError(t, err)
+	require.NoError(t, mc.Close())
+	require.NoError(t, mc.Manifest().save(manifestFilename(prefix)))
+
+	b, err := os.ReadFile(prefix + ".1.gz")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(prefix+".1.gz", b[:len(b)-4], 0o644))
+
+	err = runJoin(zap.NewNop().Sugar(), []string{"-output", filepath.Join(t.TempDir(), "joined"), prefix})
+	assert.Error(t, err)
+}
@@ -0,0 +1,358 @@
+// Package cookiejar implements http.CookieJar which is loaded from and saved to
+// Netscape cookies.txt files, the format used by curl, wget and browser
+// extensions.
+//
+// Cookies follow RFC 6265 domain, path, Secure and expiry rules. Public suffix
+// list is not used, cookies are only refused for domains without a dot, e.g.
+// Domain=com.
+package cookiejar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	header         = "# Netscape HTTP Cookie File\n# This file is generated by curly, edit at your own risk.\n\n"
+	httpOnlyPrefix = "#HttpOnly_"
+)
+
+var _ http.CookieJar = (*Jar)(nil)
+
+// Jar stores cookies, it is safe for concurrent use.
+type Jar struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	seq     int
+
+	now func() time.Time
+}
+
+type entry struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	HostOnly bool
+	Secure   bool
+	HTTPOnly bool
+	// Expires is zero for session cookies.
+	Expires time.Time
+
+	// seq orders cookies of the same path by creation.
+	seq int
+}
+
+func (e *entry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// New returns empty jar.
+func New() *Jar {
+	return &Jar{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Load returns jar with cookies of cookies.txt file name, jar is empty if the
+// file does not exist.
+func Load(name string) (*Jar, error) {
+	j := New()
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cookie jar: %w", err)
+	}
+	defer f.Close()
+	if err := j.Read(f); err != nil {
+		return nil, fmt.Errorf("unable to read cookie jar %s: %w", name, err)
+	}
+	return j, nil
+}
+
+// Read adds cookies of cookies.txt format from r, expired cookies are skipped.
+func (j *Jar) Read(r io.Reader) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), "\r")
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		if httpOnly {
+			text = text[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("line %d: expected 7 tab separated fields, got %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid expiry: %w", line, err)
+		}
+		e := &entry{
+			Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HTTPOnly: httpOnly,
+		}
+		if expires > 0 {
+			e.Expires = time.Unix(expires, 0)
+		}
+		if e.expired(now) {
+			continue
+		}
+		j.add(e)
+	}
+	return s.Err()
+}
+
+// Save writes cookies to cookies.txt file name, the file is replaced at once
+// so concurrent readers never see partial content.
+func (j *Jar) Save(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to save cookie jar: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := j.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to save cookie jar: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to save cookie jar: %w", err)
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Write writes cookies in cookies.txt format into w, expired cookies are
+// skipped and session cookies have zero expiry.
+func (j *Jar) Write(w io.Writer) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	entries := make([]*entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].seq < entries[b].seq
+	})
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(header)
+	for _, e := range entries {
+		domain, subdomains := e.Domain, "FALSE"
+		if !e.HostOnly {
+			domain, subdomains = "."+domain, "TRUE"
+		}
+		if e.HTTPOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, subdomains, e.Path, boolField(e.Secure), expires, e.Name, e.Value)
+	}
+	return bw.Flush()
+}
+
+func boolField(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// SetCookies stores cookies received from u.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	for _, c := range cookies {
+		e, ok := newEntry(c, host, u.Path, now)
+		if !ok {
+			continue
+		}
+		if e.expired(now) {
+			delete(j.entries, e.id())
+			continue
+		}
+		j.add(e)
+	}
+}
+
+// add stores e, replaced cookie keeps its creation order. j.mu must be held.
+func (j *Jar) add(e *entry) {
+	if old, ok := j.entries[e.id()]; ok {
+		e.seq = old.seq
+	} else {
+		j.seq++
+		e.seq = j.seq
+	}
+	j.entries[e.id()] = e
+}
+
+// newEntry returns entry of cookie c received from host, ok is false if host
+// must not set the cookie.
+func newEntry(c *http.Cookie, host, urlPath string, now time.Time) (e *entry, ok bool) {
+	e = &entry{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Secure:   c.Secure,
+		HTTPOnly: c.HttpOnly,
+	}
+
+	domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	switch {
+	case domain == "" || domain == host:
+		e.Domain, e.HostOnly = host, domain == ""
+	case net.ParseIP(host) != nil || !strings.Contains(domain, "."):
+		return nil, false
+	case !domainMatch(host, domain):
+		return nil, false
+	default:
+		e.Domain = domain
+	}
+
+	if !strings.HasPrefix(e.Path, "/") {
+		e.Path = defaultPath(urlPath)
+	}
+
+	switch {
+	case c.MaxAge < 0:
+		e.Expires = time.Unix(0, 0)
+	case c.MaxAge > 0:
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+	case !c.Expires.IsZero():
+		e.Expires = c.Expires
+		if !e.Expires.After(time.Unix(0, 0)) {
+			e.Expires = time.Unix(0, 0)
+		}
+	}
+	return e, true
+}
+
+// Cookies returns cookies which are sent to u, longer paths are first.
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return nil
+	}
+	secure := u.Scheme == "https"
+	urlPath := u.Path
+	if urlPath == "" {
+		urlPath = "/"
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	var selected []*entry
+	for id, e := range j.entries {
+		if e.expired(now) {
+			delete(j.entries, id)
+			continue
+		}
+		if e.Secure && !secure {
+			continue
+		}
+		if e.HostOnly && host != e.Domain || !e.HostOnly && !domainMatch(host, e.Domain) {
+			continue
+		}
+		if !pathMatch(urlPath, e.Path) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	sort.Slice(selected, func(a, b int) bool {
+		if len(selected[a].Path) != len(selected[b].Path) {
+			return len(selected[a].Path) > len(selected[b].Path)
+		}
+		return selected[a].seq < selected[b].seq
+	})
+
+	cookies := make([]*http.Cookie, len(selected))
+	for i, e := range selected {
+		cookies[i] = &http.Cookie{Name: e.Name, Value: e.Value}
+	}
+	return cookies
+}
+
+// canonicalHost returns lower case host without port and trailing dot.
+func canonicalHost(host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	return host, nil
+}
+
+// domainMatch reports whether host is domain or its subdomain, RFC 6265
+// section 5.1.3.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch reports whether cookie path matches request path, RFC 6265
+// section 5.1.4.
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultPath returns directory of request path, RFC 6265 section 5.1.4.
+func defaultPath(urlPath string) string {
+	i := strings.LastIndexByte(urlPath, '/')
+	if !strings.HasPrefix(urlPath, "/") || i == 0 {
+		return "/"
+	}
+	return urlPath[:i]
+}
@@ -0,0 +1,112 @@
+package cookiejar
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return u
+}
+
+func cookieNames(cookies []*http.Cookie) string {
+	names := make([]string, len(cookies))
+	for i, c := range cookies {
+		names[i] = c.Name + "=" + c.Value
+	}
+	return strings.Join(names, " ")
+}
+
+func TestJar_Rules(t *testing.T) {
+	now := time.Unix(1_600_000_000, 0)
+	j := New()
+	j.now = func() time.Time { return now }
+
+	j.SetCookies(mustParse(t, "http://www.example.com/app/login"), []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "secure", Value: "3", Path: "/", Secure: true},
+		{Name: "deep", Value: "4", Path: "/app/admin"},
+		{Name: "expires", Value: "5", Path: "/", MaxAge: 60},
+		{Name: "foreign", Value: "6", Domain: "other.com"},
+		{Name: "tld", Value: "7", Domain: "com"},
+	})
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://www.example.com/app/x", want: "host=1 domain=2 expires=5"},
+		{url: "https://www.example.com/", want: "domain=2 secure=3 expires=5"},
+		{url: "http://api.example.com/app/x", want: "domain=2"},
+		{url: "http://www.example.com/app/admin/users", want: "deep=4 host=1 domain=2 expires=5"},
+		{url: "http://www.example.com/application", want: "domain=2 expires=5"},
+		{url: "http://other.com/", want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, cookieNames(j.Cookies(mustParse(t, tt.url))), tt.url)
+	}
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "domain=2", cookieNames(j.Cookies(mustParse(t, "http://www.example.com/"))))
+
+	// Max-Age=0 deletes cookie
+	j.SetCookies(mustParse(t, "http://www.example.com/"), []*http.Cookie{{Name: "domain", Domain: "example.com", Path: "/", MaxAge: -1}})
+	assert.Empty(t, j.Cookies(mustParse(t, "http://www.example.com/")))
+}
+
+func TestJar_ReadWrite(t *testing.T) {
+	now := time.Unix(1_600_000_000, 0)
+	// lines of curl -c output
+	in := "# Netscape HTTP Cookie File\n" +
+		"# https://curl.se/docs/http-cookies.html\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n" +
+		"#HttpOnly_www.example.com\tFALSE\t/app\tTRUE\t1700000000\ttoken\tsecret\n" +
+		"old.example.com\tFALSE\t/\tFALSE\t1500000000\texpired\tx\n"
+
+	j := New()
+	j.now = func() time.Time { return now }
+	require.NoError(t, j.Read(strings.NewReader(in)))
+
+	assert.Equal(t, "session=abc", cookieNames(j.Cookies(mustParse(t, "http://api.example.com/app"))))
+	assert.Equal(t, "token=secret session=abc", cookieNames(j.Cookies(mustParse(t, "https://www.example.com/app"))))
+	assert.Equal(t, "session=abc", cookieNames(j.Cookies(mustParse(t, "http://old.example.com/"))))
+
+	var buf bytes.Buffer
+	require.NoError(t, j.Write(&buf))
+	assert.Equal(t, header+
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n"+
+		"#HttpOnly_www.example.com\tFALSE\t/app\tTRUE\t1700000000\ttoken\tsecret\n", buf.String())
+
+	assert.Error(t, New().Read(strings.NewReader("example.com\tTRUE\t/\n")))
+}
+
+func TestJar_LoadSave(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cookies.txt")
+	j, err := Load(name)
+	require.NoError(t, err)
+
+	u := mustParse(t, "http://example.com/")
+	j.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1", Expires: time.Now().Add(time.Hour)}})
+	require.NoError(t, j.Save(name))
+
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	j, err = Load(name)
+	require.NoError(t, err)
+	assert.Equal(t, "a=1", cookieNames(j.Cookies(u)))
+}
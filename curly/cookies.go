@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/cookiejar"
+)
+
+// cookieFlags are -cookie-jar and -b flags.
+type cookieFlags struct {
+	jar     string
+	cookies []string
+}
+
+func (f *cookieFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.jar, "cookie-jar", "", "FILE in Netscape cookies.txt format, cookies are loaded from FILE and received cookies are saved to it")
+	fs.Func("b", "cookies 'name=value; name2=value2' sent with download request, value without = is cookies.txt FILE which is read but not saved, can be repeated", func(v string) error {
+		f.cookies = append(f.cookies, v)
+		return nil
+	})
+}
+
+// apply loads cookie jar into cfg and adds -b cookies to cfg.Header.
+func (f *cookieFlags) apply(cfg *Config) error {
+	cfg.CookieJar = f.jar
+	if f.jar != "" {
+		var err error
+		if cfg.Jar, err = cookiejar.Load(f.jar); err != nil {
+			return err
+		}
+	}
+
+	var pairs []string
+	for _, v := range f.cookies {
+		if !strings.Contains(v, "=") {
+			if err := readCookieFile(cfg, v); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, pair := range strings.Split(v, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			if i := strings.IndexByte(pair, '='); i <= 0 {
+				return fmt.Errorf("cookie %q must be in format name=value", pair)
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	// user agent must not send more than one Cookie header, RFC 6265
+	cookie := strings.Join(pairs, "; ")
+	if cfg.Header == nil {
+		cfg.Header = make(http.Header)
+	}
+	if prev := cfg.Header.Get("Cookie"); prev != "" {
+		cookie = prev + "; " + cookie
+	}
+	cfg.Header.Set("Cookie", cookie)
+	return nil
+}
+
+func readCookieFile(cfg *Config, name string) error {
+	if cfg.Jar == nil {
+		cfg.Jar = cookiejar.New()
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("unable to open cookie file: %w", err)
+	}
+	defer f.Close()
+	if err := cfg.Jar.Read(f); err != nil {
+		return fmt.Errorf("unable to read cookie file %s: %w", name, err)
+	}
+	return nil
+}
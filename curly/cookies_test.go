@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCookieFlags(t *testing.T) {
+	cookieFile := filepath.Join(t.TempDir(), "cookies.txt")
+	require.NoError(t, os.WriteFile(cookieFile, []byte("example.com\tFALSE\t/\tFALSE\t0\tfile\t1\n"), 0o644))
+
+	var cf cookieFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf.register(fs)
+	require.NoError(t, fs.Parse([]string{"-b", "a=1; b=2", "-b", cookieFile, "-b", "c=3"}))
+	cfg := &Config{Header: http.Header{"Cookie": {"h=0"}}}
+	require.NoError(t, cf.apply(cfg))
+
+	assert.Equal(t, "h=0; a=1; b=2; c=3", cfg.Header.Get("Cookie"))
+	require.NotNil(t, cfg.Jar)
+	u, err := url.Parse("http://example.com/")
+	require.NoError(t, err)
+	assert.Len(t, cfg.Jar.Cookies(u), 1)
+	assert.Empty(t, cfg.CookieJar)
+
+	cf = cookieFlags{cookies: []string{"=nope"}}
+	assert.Error(t, cf.apply(&Config{}))
+}
+
+func TestCookieJar_Session(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/", MaxAge: 3600})
+		case "/file":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "s3cr3t" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("private " + r.Header.Get("Cookie")))
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	jarFile := filepath.Join(dir, "cookies.txt")
+	// every run loads the jar, downloads and saves the jar as run() does
+	get := func(p string, cookies ...string) string {
+		cf := cookieFlags{jar: jarFile, cookies: cookies}
+		u, err := url.Parse(ts.URL + p)
+		require.NoError(t, err)
+		cfg := &Config{
+			DownloadURL: u,
+			Output:      filepath.Join(dir, "out"),
+			Progress:    progressNone,
+		}
+		require.NoError(t, cf.apply(cfg))
+		_, err = download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+		require.NoError(t, err)
+		require.NoError(t, cfg.Jar.Save(jarFile))
+		b, err := os.ReadFile(cfg.Output)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "unauthorized\n", get("/file"))
+	get("/login")
+	b, err := os.ReadFile(jarFile)
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(b), "\tsession\ts3cr3t\n"))
+	assert.Equal(t, "private one=1; session=s3cr3t", get("/file", "one=1"))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// acceptEncoding is sent by -compressed, encodings are in order of
+// preference.
+const acceptEncoding = "br, zstd, gzip, deflate"
+
+// decodeResponse returns body of resp decoded by its Content-Encoding. The
+// size of decoded content is not known, so it is -1 when body is encoded.
+// Encoded and decoded sizes are logged on Close in verbose mode.
+func decodeResponse(log *zap.SugaredLogger, cfg *Config, resp *http.Response) (io.ReadCloser, int64, error) {
+	encoding := resp.Header.Get("Content-Encoding")
+	if cfg.Raw || !cfg.Compressed || encoding == "" || strings.EqualFold(encoding, "identity") {
+		return resp.Body, resp.ContentLength, nil
+	}
+
+	encoded := &countingReader{r: resp.Body}
+	decoded, err := newContentDecoder(encoding, encoded)
+	if err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	counted := &countingReader{r: decoded}
+	return readCloser{
+		Reader: counted,
+		Closer: closerFunc(func() error {
+			if cfg.Verbose {
+				log.Debugf("content encoding %s: %d encoded bytes, %d decoded bytes", encoding, encoded.n, counted.n)
+			}
+			return multierr.Combine(decoded.Close(), resp.Body.Close())
+		}),
+	}, -1, nil
+}
+
+// newContentDecoder returns reader of r decoded by Content-Encoding list,
+// encodings are removed in reverse order they were applied.
+func newContentDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	codings := strings.Split(encoding, ",")
+	var closers []io.Closer
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		d, err := newDecoder(coding, r)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		closers = append(closers, d)
+		r = d
+	}
+	return readCloser{
+		Reader: r,
+		Closer: closerFunc(func() error {
+			return closeAll(closers)
+		}),
+	}, nil
+}
+
+// closeAll closes decoders from the outermost one.
+func closeAll(closers []io.Closer) error {
+	var err error
+	for i := len(closers) - 1; i >= 0; i-- {
+		err = multierr.Append(err, closers[i].Close())
+	}
+	return err
+}
+
+func newDecoder(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch coding {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return newDecompressor("gzip", r)
+	case "zstd":
+		return newDecompressor("zstd", r)
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "deflate":
+		// deflate should be zlib stream, RFC 9110 8.4.1.2, but some servers
+		// send raw deflate
+		br := bufio.NewReader(r)
+		if b, err := br.Peek(2); err == nil && isZlibHeader(b) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", coding)
+	}
+}
+
+// isZlibHeader returns true for CMF and FLG bytes of zlib stream compressed
+// by deflate, RFC 1950.
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func encode(t *testing.T, coding string, b []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown coding %s", coding)
+	}
+	require.NoError(t, err)
+	_, err = w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDownload_Compressed(t *testing.T) {
+	content := []byte(strings.Repeat("compressible content ", 1000))
+	var gotAcceptEncoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAcceptEncoding = r.Header.Get("Accept-Encoding")
+		b := content
+		for _, coding := range strings.Split(r.URL.Query().Get("encoding"), ",") {
+			// compress is sent unencoded, it is not supported by client
+			if coding != "" && coding != "compress" {
+				b = encode(t, coding, b)
+			}
+		}
+		w.Header().Set("Content-Encoding", strings.ReplaceAll(r.URL.Query().Get("encoding"), "raw-deflate", "deflate"))
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		encoding string
+		raw      bool
+		wantErr  string
+	}{
+		{encoding: ""},
+		{encoding: "gzip"},
+		{encoding: "deflate"},
+		{encoding: "raw-deflate"},
+		{encoding: "br"},
+		{encoding: "zstd"},
+		{encoding: "gzip,br"},
+		{encoding: "zstd", raw: true},
+		{encoding: "compress", wantErr: `unsupported Content-Encoding "compress"`},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s raw %t", tt.encoding, tt.raw), func(t *testing.T) {
+			u, err := url.Parse(ts.URL + "/file?encoding=" + tt.encoding)
+			require.NoError(t, err)
+			core, logs := observer.New(zap.DebugLevel)
+			dir := t.TempDir()
+			cfg := &Config{
+				DownloadURL: u,
+				Method:      http.MethodGet,
+				Header:      http.Header{"Accept-Encoding": {acceptEncoding}},
+				Compressed:  true,
+				Raw:         tt.raw,
+				Verbose:     true,
+				Output:      filepath.Join(dir, "out"),
+				Progress:    progressNone,
+			}
+			n, err := download(zap.New(core).Sugar(), ts.Client(), cfg)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "br, zstd, gzip, deflate", gotAcceptEncoding)
+
+			got, err := os.ReadFile(cfg.Output)
+			require.NoError(t, err)
+			if tt.raw {
+				assert.Equal(t, encode(t, tt.encoding, content), got)
+				return
+			}
+			assert.Equal(t, content, got)
+			assert.Equal(t, int64(len(content)), n)
+
+			if tt.encoding != "" {
+				entries := logs.FilterMessageSnippet("content encoding").All()
+				require.Len(t, entries, 1)
+				assert.Contains(t, entries[0].Message, fmt.Sprintf("encoded bytes, %d decoded bytes", len(content)))
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+// Package encrypt implements streaming authenticated encryption.
+//
+// Content is split into segments of SegmentSize bytes and every segment is
+// sealed by ChaCha20-Poly1305 (STREAM construction). Nonce of a segment
+// contains segment counter and flag of the last segment, so reordered,
+// removed or truncated segments are detected as well as modified ones.
+//
+// Encrypted stream starts with header:
+//
+//	magic "CURLYENC" | version (1 byte) | kdf (1 byte) | kdf salt (16 bytes) | nonce (16 bytes)
+//
+// Key of the stream is derived by HKDF-SHA256 from master key and the random
+// nonce. Master key is either read from key file or derived from passphrase by
+// scrypt with the salt from header.
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// SegmentSize is size of plaintext sealed at once.
+	SegmentSize = 64 << 10
+	// HeaderSize is size of stream header.
+	HeaderSize = len(magic) + 2 + saltSize + nonceSize
+
+	magic     = "CURLYENC"
+	version   = 1
+	saltSize  = 16
+	nonceSize = 16
+	keySize   = chacha20poly1305.KeySize
+	tagSize   = 16 // Poly1305 tag
+
+	kdfKey    = 0
+	kdfScrypt = 1
+)
+
+// ErrAuthentication is returned when encrypted content was modified,
+// truncated or the key is wrong.
+var ErrAuthentication = errors.New("encrypt: message authentication failed")
+
+// Overhead returns number of bytes added by encryption to n bytes of content.
+func Overhead(n int64) int64 {
+	segments := (n + SegmentSize - 1) / SegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(HeaderSize) + segments*tagSize
+}
+
+// Secret is a key or passphrase used to encrypt and decrypt streams. Keys
+// derived from passphrase are cached, so scrypt runs once for all streams
+// encrypted by one Secret.
+type Secret struct {
+	key        []byte
+	passphrase []byte
+
+	mu      sync.Mutex
+	salt    []byte
+	derived map[string][]byte
+}
+
+// NewKey returns secret of 32 bytes long key.
+func NewKey(key []byte) (*Secret, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encrypt: key must be %d bytes long, got %d", keySize, len(key))
+	}
+	return &Secret{key: key}, nil
+}
+
+// ReadKeyFile reads key file which contains 32 bytes long key either raw or
+// hex encoded.
+func ReadKeyFile(name string) (*Secret, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: unable to read key file: %w", err)
+	}
+	if len(b) != keySize {
+		if b, err = hex.DecodeString(string(bytes.TrimSpace(b))); err != nil {
+			return nil, fmt.Errorf("encrypt: key file must contain %d bytes or %d hex characters", keySize, 2*keySize)
+		}
+	}
+	return NewKey(b)
+}
+
+// NewPassphrase returns secret which derives keys from passphrase.
+func NewPassphrase(passphrase []byte) (*Secret, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("encrypt: passphrase is empty")
+	}
+	return &Secret{
+		passphrase: passphrase,
+		derived:    make(map[string][]byte),
+	}, nil
+}
+
+// encryptionKey returns master key, kdf and salt written into header of new stream.
+func (s *Secret) encryptionKey() (key []byte, kdf byte, salt []byte, err error) {
+	if s.key != nil {
+		return s.key, kdfKey, make([]byte, saltSize), nil
+	}
+
+	s.mu.Lock()
+	if s.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			s.mu.Unlock()
+			return nil, 0, nil, fmt.Errorf("encrypt: unable to generate salt: %w", err)
+		}
+		s.salt = salt
+	}
+	salt = s.salt
+	s.mu.Unlock()
+
+	key, err = s.decryptionKey(kdfScrypt, salt)
+	return key, kdfScrypt, salt, err
+}
+
+// decryptionKey returns master key of stream with given kdf and salt.
+func (s *Secret) decryptionKey(kdf byte, salt []byte) ([]byte, error) {
+	switch {
+	case kdf == kdfKey && s.key != nil:
+		return s.key, nil
+	case kdf == kdfScrypt && s.passphrase != nil:
+	default:
+		return nil, fmt.Errorf("encrypt: stream was encrypted by %s", kdfName(kdf))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(s.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: unable to derive key: %w", err)
+	}
+	s.derived[string(salt)] = key
+	return key, nil
+}
+
+func kdfName(kdf byte) string {
+	switch kdf {
+	case kdfKey:
+		return "key file"
+	case kdfScrypt:
+		return "passphrase"
+	default:
+		return fmt.Sprintf("unknown kdf %d", kdf)
+	}
+}
+
+func streamAEAD(masterKey []byte, nonce []byte) (cipher.AEAD, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, nonce, []byte("curly encrypt v1")), key); err != nil {
+		return nil, fmt.Errorf("encrypt: unable to derive stream key: %w", err)
+	}
+	return chacha20poly1305.New(key)
+}
+
+// segmentNonce is 11 bytes big endian counter followed by last segment flag.
+func segmentNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+var _ io.WriteCloser = (*writer)(nil)
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	out     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewWriter returns writer which encrypts content into w. Additional data ad
+// are authenticated but not encrypted, the same ad must be passed to NewReader.
+// Close must be called to write the last segment, it does not close w.
+func NewWriter(w io.Writer, s *Secret, ad []byte) (io.WriteCloser, error) {
+	key, kdf, salt, err := s.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encrypt: unable to generate nonce: %w", err)
+	}
+	aead, err := streamAEAD(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, HeaderSize)
+	header = append(header, magic...)
+	header = append(header, version, kdf)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		w:    w,
+		aead: aead,
+		ad:   ad,
+		buf:  make([]byte, 0, SegmentSize),
+		out:  make([]byte, 0, SegmentSize+tagSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("encrypt: write to closed writer")
+	}
+	var n int
+	for len(p) > 0 {
+		// full segment is sealed when more data comes, so the last segment
+		// is always sealed by Close
+		if len(w.buf) == SegmentSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):SegmentSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (w *writer) seal(last bool) error {
+	w.out = w.aead.Seal(w.out[:0], segmentNonce(w.counter, last), w.buf, w.ad)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	in      []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+// NewReader returns reader which decrypts content of r. ErrAuthentication is
+// returned by Read if content was modified or truncated.
+func NewReader(r io.Reader, s *Secret, ad []byte) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("encrypt: unable to read header: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("encrypt: content is not encrypted by curly")
+	}
+	header = header[len(magic):]
+	if header[0] != version {
+		return nil, fmt.Errorf("encrypt: unsupported version %d", header[0])
+	}
+	kdf, salt, nonce := header[1], header[2:2+saltSize], header[2+saltSize:]
+
+	key, err := s.decryptionKey(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := streamAEAD(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		r:    bufio.NewReaderSize(r, SegmentSize+tagSize),
+		aead: aead,
+		ad:   ad,
+		in:   make([]byte, SegmentSize+tagSize),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.r, r.in)
+	var last bool
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// full segment is the last one if nothing follows
+		if _, err := r.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < tagSize {
+		return ErrAuthentication
+	}
+
+	r.buf, err = r.aead.Open(r.in[:0], segmentNonce(r.counter, last), r.in[:n], r.ad)
+	if err != nil {
+		return ErrAuthentication
+	}
+	r.counter++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,165 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, s *Secret, ad []byte, content []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, s, ad)
+	require.NoError(t, err)
+	_, err = io.CopyBuffer(w, bytes.NewReader(content), make([]byte, 1000))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decrypt(s *Secret, ad []byte, encrypted []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(encrypted), s, ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key, err := NewKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	passphrase, err := NewPassphrase([]byte("correct horse battery staple"))
+	require.NoError(t, err)
+
+	random := make([]byte, 3*SegmentSize+123)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: nil},
+		{name: "short", content: []byte("hello")},
+		{name: "exactly one segment", content: random[:SegmentSize]},
+		{name: "exactly two segments", content: random[:2*SegmentSize]},
+		{name: "more segments", content: random},
+	}
+	for _, s := range []*Secret{key, passphrase} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				encrypted := encrypt(t, s, []byte("ad"), tt.content)
+				assert.Equal(t, int64(len(tt.content))+Overhead(int64(len(tt.content))), int64(len(encrypted)))
+
+				got, err := decrypt(s, []byte("ad"), encrypted)
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(tt.content, got))
+			})
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	s, err := NewPassphrase([]byte("secret"))
+	require.NoError(t, err)
+	content := make([]byte, 2*SegmentSize+10)
+	encrypted := encrypt(t, s, []byte("chunk 1"), content)
+	segment := SegmentSize + tagSize
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		ad     string
+		secret string
+	}{
+		{
+			name: "flipped bit",
+			modify: func(b []byte) []byte {
+				b[HeaderSize+10] ^= 1
+				return b
+			},
+		},
+		{
+			name: "truncated at segment boundary",
+			modify: func(b []byte) []byte {
+				return b[:HeaderSize+2*segment]
+			},
+		},
+		{
+			name: "truncated",
+			modify: func(b []byte) []byte {
+				return b[:len(b)-1]
+			},
+		},
+		{
+			name: "swapped segments",
+			modify: func(b []byte) []byte {
+				s1 := append([]byte{}, b[HeaderSize:HeaderSize+segment]...)
+				copy(b[HeaderSize:], b[HeaderSize+segment:HeaderSize+2*segment])
+				copy(b[HeaderSize+segment:], s1)
+				return b
+			},
+		},
+		{
+			name: "modified salt",
+			modify: func(b []byte) []byte {
+				b[len(magic)+2] ^= 1
+				return b
+			},
+		},
+		{
+			name:   "different additional data",
+			modify: func(b []byte) []byte { return b },
+			ad:     "chunk 2",
+		},
+		{
+			name:   "wrong passphrase",
+			modify: func(b []byte) []byte { return b },
+			secret: "wrong",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.modify(append([]byte{}, encrypted...))
+			ad := "chunk 1"
+			if tt.ad != "" {
+				ad = tt.ad
+			}
+			secret := s
+			if tt.secret != "" {
+				secret, err = NewPassphrase([]byte(tt.secret))
+				require.NoError(t, err)
+			}
+			_, err := decrypt(secret, []byte(ad), b)
+			assert.True(t, errors.Is(err, ErrAuthentication), "got %v", err)
+		})
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	raw := bytes.Repeat([]byte{7}, 32)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "raw"), raw, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hex"), []byte("0707070707070707070707070707070707070707070707070707070707070707\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "short"), []byte("short"), 0o600))
+
+	rawKey, err := ReadKeyFile(filepath.Join(dir, "raw"))
+	require.NoError(t, err)
+	hexKey, err := ReadKeyFile(filepath.Join(dir, "hex"))
+	require.NoError(t, err)
+	assert.Equal(t, rawKey.key, hexKey.key)
+
+	_, err = ReadKeyFile(filepath.Join(dir, "short"))
+	assert.Error(t, err)
+
+	// stream encrypted by key can not be decrypted by passphrase
+	passphrase, err := NewPassphrase([]byte("secret"))
+	require.NoError(t, err)
+	_, err = decrypt(passphrase, nil, encrypt(t, rawKey, nil, []byte("hello")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/multierr"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
+)
+
+const (
+	// encryptedExt is file extension of encrypted chunks and manifest,
+	// e.g. FILEPREFIX.0.gz.enc
+	encryptedExt = ".enc"
+
+	// passphraseEnv is environment variable with passphrase used when neither
+	// -key-file nor -passphrase-file is set.
+	passphraseEnv = "CURLY_PASSPHRASE"
+)
+
+// manifestAD is additional data of encrypted manifest.
+var manifestAD = []byte("curly manifest")
+
+// chunkAD returns additional data of encrypted chunk idx, so encrypted chunks
+// can not be swapped or renamed without being detected.
+func chunkAD(idx int) []byte {
+	return []byte(fmt.Sprintf("curly chunk %d", idx))
+}
+
+// chunkExt returns file extension of chunks with given compression.
+func chunkExt(compression string, encrypted bool) string {
+	ext := compressExt[compression]
+	if encrypted {
+		ext += encryptedExt
+	}
+	return ext
+}
+
+// secretFlags are flags of encryption key shared by download, join and
+// decrypt commands.
+type secretFlags struct {
+	keyFile        string
+	passphraseFile string
+}
+
+func (f *secretFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.keyFile, "key-file", "", "file with 32 bytes long encryption key, raw or hex encoded")
+	fs.StringVar(&f.passphraseFile, "passphrase-file", "", "file with encryption passphrase, key is derived by scrypt, passphrase can be set by "+passphraseEnv+" env as well")
+}
+
+// set returns true if key or passphrase file was specified.
+func (f *secretFlags) set() bool {
+	return f.keyFile != "" || f.passphraseFile != ""
+}
+
+// secret returns secret specified by flags or by passphraseEnv, nil secret is
+// returned when there is none.
+func (f *secretFlags) secret() (*encrypt.Secret, error) {
+	switch {
+	case f.keyFile != "" && f.passphraseFile != "":
+		return nil, fmt.Errorf("-key-file can not be combined with -passphrase-file")
+	case f.keyFile != "":
+		return encrypt.ReadKeyFile(f.keyFile)
+	case f.passphraseFile != "":
+		b, err := os.ReadFile(f.passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase file: %w", err)
+		}
+		// passphrase files usually end with new line
+		return encrypt.NewPassphrase(bytes.TrimRight(b, "\r\n"))
+	case os.Getenv(passphraseEnv) != "":
+		return encrypt.NewPassphrase([]byte(os.Getenv(passphraseEnv)))
+	default:
+		return nil, nil
+	}
+}
+
+var _ Chunker = (*encryptChunker)(nil)
+
+// encryptChunker encrypts every chunk separately so each chunk can be
+// decrypted and verified on its own.
+type encryptChunker struct {
+	Chunker
+	secret *encrypt.Secret
+	idx    int
+	ew     io.WriteCloser
+}
+
+// newEncryptChunker encrypts chunks written into c by secret s. File names of
+// chunks should have encryptedExt extension.
+func newEncryptChunker(c Chunker, s *encrypt.Secret) (*encryptChunker, error) {
+	ec := &encryptChunker{
+		Chunker: c,
+		secret:  s,
+	}
+	if err := ec.start(); err != nil {
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (c *encryptChunker) start() error {
+	var err error
+	c.ew, err = encrypt.NewWriter(c.Chunker, c.secret, chunkAD(c.idx))
+	return err
+}
+
+func (c *encryptChunker) Write(p []byte) (int, error) {
+	return c.ew.Write(p)
+}
+
+func (c *encryptChunker) NewChunk() error {
+	if err := c.ew.Close(); err != nil {
+		return fmt.Errorf("unable to encrypt chunk: %w", err)
+	}
+	if err := c.Chunker.NewChunk(); err != nil {
+		return err
+	}
+	c.idx++
+	return c.start()
+}
+
+func (c *encryptChunker) Close() error {
+	return multierr.Combine(c.ew.Close(), c.Chunker.Close())
+}
+
+// runDecrypt implements `curly decrypt [flags] FILE` which decrypts an upload,
+// chunk or manifest encrypted by -encrypt. Output file is removed when content
+// can not be decrypted or it was tampered with.
+func runDecrypt(args []string) (err error) {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	output := fs.String("output", "-", "decrypted file, if value is '-' output is stdout")
+	chunk := fs.Int("chunk", -1, "FILE is -output-chunked chunk N")
+	manifest := fs.Bool("manifest", false, "FILE is encrypted manifest of chunks")
+	var sf secretFlags
+	sf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: curly decrypt [flags] FILE")
+	}
+	if *chunk >= 0 && *manifest {
+		return fmt.Errorf("-chunk can not be combined with -manifest")
+	}
+	secret, err := sf.secret()
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no key specified, use -key-file, -passphrase-file or %s env", passphraseEnv)
+	}
+
+	var ad []byte
+	switch {
+	case *chunk >= 0:
+		ad = chunkAD(*chunk)
+	case *manifest:
+		ad = manifestAD
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("unable to open encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	w := io.Writer(stdout)
+	if *output != "-" {
+		f, createErr := os.Create(*output)
+		if createErr != nil {
+			return fmt.Errorf("unable to create os file: %w", createErr)
+		}
+		defer func() {
+			f.Close()
+			if err != nil {
+				os.Remove(*output)
+			}
+		}()
+		w = f
+	}
+
+	r, err := encrypt.NewReader(in, secret, ad)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("unable to decrypt %s: %w", fs.Arg(0), err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
+)
+
+// writeEncryptedChunks writes content by the same chunk stack as -output-chunked
+// with -encrypt and returns path of the key file.
+func writeEncryptedChunks(t *testing.T, cfg *Config, content []byte) string {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, bytes.Repeat([]byte{42}, 32), 0o600))
+	var err error
+	cfg.Secret, err = encrypt.ReadKeyFile(keyFile)
+	require.NoError(t, err)
+	cfg.DownloadURL, _ = url.Parse("http://example.com/foo")
+
+	chunked, mc, err := openChunked(cfg, int64(len(content)))
+	require.NoError(t, err)
+	_, err = io.CopyBuffer(chunked, bytes.NewReader(content), make([]byte, 32<<10))
+	require.NoError(t, err)
+	require.NoError(t, mc.Close())
+	require.NoError(t, mc.Manifest().saveEncrypted(encryptedManifestFilename(cfg.ChunkedPrefix), cfg.Secret))
+	return keyFile
+}
+
+func TestEncryptedChunks(t *testing.T) {
+	content := make([]byte, 300_000)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "raw", cfg: Config{ChunkSize: 64 << 10}},
+		{name: "compressed", cfg: Config{ChunkSize: 64 << 10, ChunkCompress: "zstd"}},
+		{name: "compressed target", cfg: Config{ChunkSize: 64 << 10, ChunkCompress: "gzip", CompressedChunkSize: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.ChunkedPrefix = filepath.Join(t.TempDir(), "foo")
+			keyFile := writeEncryptedChunks(t, &cfg, content)
+
+			_, err := os.Stat(manifestFilename(cfg.ChunkedPrefix))
+			assert.True(t, os.IsNotExist(err), "plain manifest must not be written")
+			chunks, err := findChunks(cfg.ChunkedPrefix)
+			require.NoError(t, err)
+			for _, c := range chunks {
+				assert.True(t, c.encrypted)
+				if cfg.CompressedChunkSize {
+					assert.LessOrEqual(t, c.size, cfg.ChunkSize)
+				}
+			}
+
+			for _, args := range [][]string{
+				{cfg.ChunkedPrefix},
+				// join without manifest decrypts chunks found by name
+				{"-chunk-size", "64KiB", cfg.ChunkedPrefix},
+			} {
+				if len(args) > 1 {
+					require.NoError(t, os.Remove(encryptedManifestFilename(cfg.ChunkedPrefix)))
+				}
+				output := filepath.Join(t.TempDir(), "joined")
+				err = runJoin(zap.NewNop().Sugar(), append([]string{"-key-file", keyFile, "-output", output}, args...))
+				require.NoError(t, err)
+				got, err := os.ReadFile(output)
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(content, got))
+			}
+		})
+	}
+}
+
+func TestEncryptedChunks_Tampered(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 30_000)
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, chunks []chunkFile)
+		noKey  bool
+	}{
+		{
+			name: "flipped bit",
+			modify: func(t *testing.T, chunks []chunkFile) {
+				b, err := os.ReadFile(chunks[1].name)
+				require.NoError(t, err)
+				b[len(b)/2] ^= 1
+				require.NoError(t, os.WriteFile(chunks[1].name, b, 0o644))
+			},
+		},
+		{
+			name: "swapped chunks",
+			modify: func(t *testing.T, chunks []chunkFile) {
+				b0, err := os.ReadFile(chunks[0].name)
+				require.NoError(t, err)
+				b1, err := os.ReadFile(chunks[1].name)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(chunks[0].name, b1, 0o644))
+				require.NoError(t, os.WriteFile(chunks[1].name, b0, 0o644))
+			},
+		},
+		{
+			name: "truncated last chunk",
+			modify: func(t *testing.T, chunks []chunkFile) {
+				last := chunks[len(chunks)-1]
+				require.NoError(t, os.Truncate(last.name, last.size-1))
+			},
+		},
+		{
+			name:   "missing key",
+			modify: func(t *testing.T, chunks []chunkFile) {},
+			noKey:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				ChunkedPrefix: filepath.Join(t.TempDir(), "foo"),
+				ChunkSize:     64 << 10,
+				ChunkCompress: "gzip",
+			}
+			keyFile := writeEncryptedChunks(t, &cfg, content)
+			chunks, err := findChunks(cfg.ChunkedPrefix)
+			require.NoError(t, err)
+			tt.modify(t, chunks)
+
+			// tampering is detected with and without manifest
+			for _, removeManifest := range []bool{false, true} {
+				if removeManifest {
+					os.Remove(encryptedManifestFilename(cfg.ChunkedPrefix))
+				}
+				args := []string{"-output", filepath.Join(t.TempDir(), "joined"), cfg.ChunkedPrefix}
+				if !tt.noKey {
+					args = append([]string{"-key-file", keyFile}, args...)
+				}
+				err = runJoin(zap.NewNop().Sugar(), args)
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRunDecrypt(t *testing.T) {
+	content := bytes.Repeat([]byte("hello decrypt "), 1000)
+	cfg := Config{
+		ChunkedPrefix: filepath.Join(t.TempDir(), "foo"),
+		ChunkSize:     4 << 10,
+	}
+	keyFile := writeEncryptedChunks(t, &cfg, content)
+
+	output := filepath.Join(t.TempDir(), "chunk")
+	require.NoError(t, runDecrypt([]string{"-key-file", keyFile, "-chunk", "1", "-output", output, filename(cfg.ChunkedPrefix, 1) + encryptedExt}))
+	got, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, content[4<<10:8<<10], got)
+
+	// chunk decrypted as other chunk fails and output is removed
+	err = runDecrypt([]string{"-key-file", keyFile, "-chunk", "2", "-output", output, filename(cfg.ChunkedPrefix, 1) + encryptedExt})
+	assert.ErrorIs(t, err, encrypt.ErrAuthentication)
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, runDecrypt([]string{"-key-file", keyFile, "-manifest", "-output", output, encryptedManifestFilename(cfg.ChunkedPrefix)}))
+	m, err := loadManifest(output)
+	require.NoError(t, err)
+	assert.True(t, m.Encrypted)
+	assert.Equal(t, int64(len(content)), m.Size)
+}
@@ -0,0 +1,234 @@
+// Package erasure implements systematic Reed-Solomon erasure code over GF(2^8).
+//
+// N data shards are extended by K parity shards of the same size, any N of
+// the N+K shards are enough to rebuild the others. Parity shard p is a linear
+// combination of data shards with coefficients of Cauchy matrix
+//
+//	c(p, i) = 1 / ((255 - p) xor i)
+//
+// Every square submatrix of Cauchy matrix is invertible, so the code can
+// recover from any K lost shards. Coefficients do not depend on N or K, so
+// N+K must not exceed MaxShards.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the maximum number of data and parity shards together.
+const MaxShards = 256
+
+// ErrTooFewShards is returned when there are not enough shards to rebuild the
+// missing ones.
+var ErrTooFewShards = errors.New("erasure: too few shards")
+
+var (
+	expTable [2 * 255]byte
+	logTable [256]byte
+)
+
+func init() {
+	// generator 2 of GF(2^8) with polynomial x^8 + x^4 + x^3 + x^2 + 1
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func inv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+func coefficient(p, i int) byte {
+	return inv(byte(255-p) ^ byte(i))
+}
+
+// mulAdd adds c * src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	var table [256]byte
+	for x := 1; x < 256; x++ {
+		table[x] = mul(c, byte(x))
+	}
+	for j, b := range src {
+		dst[j] ^= table[b]
+	}
+}
+
+func checkShards(dataShards, parityShards int) error {
+	if dataShards < 1 || parityShards < 0 {
+		return fmt.Errorf("erasure: invalid number of shards %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > MaxShards {
+		return fmt.Errorf("erasure: %d data and %d parity shards exceed maximum %d shards", dataShards, parityShards, MaxShards)
+	}
+	return nil
+}
+
+func checkSize(shards [][]byte) error {
+	for _, s := range shards {
+		if len(s) != len(shards[0]) {
+			return fmt.Errorf("erasure: shards differ in size %d and %d", len(shards[0]), len(s))
+		}
+	}
+	return nil
+}
+
+// Encode computes parity shards of data shards, all shards must have the same
+// size.
+func Encode(data [][]byte, parity [][]byte) error {
+	if err := checkShards(len(data), len(parity)); err != nil {
+		return err
+	}
+	if err := checkSize(append(append([][]byte{}, data...), parity...)); err != nil {
+		return err
+	}
+	for p := range parity {
+		encodeParity(data, parity[p], p)
+	}
+	return nil
+}
+
+func encodeParity(data [][]byte, parity []byte, p int) {
+	for j := range parity {
+		parity[j] = 0
+	}
+	for i := range data {
+		mulAdd(parity, data[i], coefficient(p, i))
+	}
+}
+
+// Reconstructor rebuilds missing shards. Reconstruction matrix is computed
+// once, so shards can be rebuilt block by block.
+type Reconstructor struct {
+	dataShards int
+	present    []bool
+	// used are indexes of shards data are rebuilt from
+	used []int
+	// rows are coefficients of missing data shards over used shards
+	rows map[int][]byte
+}
+
+// NewReconstructor returns Reconstructor of shards which are not present,
+// present has an item for every data shard followed by parity shards.
+func NewReconstructor(dataShards int, present []bool) (*Reconstructor, error) {
+	if err := checkShards(dataShards, len(present)-dataShards); err != nil {
+		return nil, err
+	}
+	r := &Reconstructor{
+		dataShards: dataShards,
+		present:    present,
+		rows:       make(map[int][]byte),
+	}
+	for i, ok := range present {
+		if ok && len(r.used) < dataShards {
+			r.used = append(r.used, i)
+		}
+	}
+	if len(r.used) < dataShards {
+		return nil, fmt.Errorf("%w: %d shards present, %d required", ErrTooFewShards, len(r.used), dataShards)
+	}
+
+	// rows of generator matrix of used shards
+	m := make([][]byte, dataShards)
+	for row, idx := range r.used {
+		m[row] = make([]byte, dataShards)
+		if idx < dataShards {
+			m[row][idx] = 1
+			continue
+		}
+		for i := range m[row] {
+			m[row][i] = coefficient(idx-dataShards, i)
+		}
+	}
+	inverted, err := invert(m)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < dataShards; i++ {
+		if !present[i] {
+			r.rows[i] = inverted[i]
+		}
+	}
+	return r, nil
+}
+
+// Reconstruct overwrites missing shards by rebuilt content. All shards must be
+// allocated and have the same size, content of missing shards is ignored.
+func (r *Reconstructor) Reconstruct(shards [][]byte) error {
+	if len(shards) != len(r.present) {
+		return fmt.Errorf("erasure: got %d shards, expected %d", len(shards), len(r.present))
+	}
+	if err := checkSize(shards); err != nil {
+		return err
+	}
+	for i, row := range r.rows {
+		dst := shards[i]
+		for j := range dst {
+			dst[j] = 0
+		}
+		for k, idx := range r.used {
+			mulAdd(dst, shards[idx], row[k])
+		}
+	}
+	data := shards[:r.dataShards]
+	for p, ok := range r.present[r.dataShards:] {
+		if !ok {
+			encodeParity(data, shards[r.dataShards+p], p)
+		}
+	}
+	return nil
+}
+
+// invert returns inverse of square matrix m by Gauss-Jordan elimination, m is
+// modified.
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = make([]byte, n)
+		out[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("erasure: singular matrix")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		out[col], out[pivot] = out[pivot], out[col]
+
+		c := inv(m[col][col])
+		for j := 0; j < n; j++ {
+			m[col][j] = mul(m[col][j], c)
+			out[col][j] = mul(out[col][j], c)
+		}
+		for row := 0; row < n; row++ {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			c := m[row][col]
+			mulAdd(m[row], m[col], c)
+			mulAdd(out[row], out[col], c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shards(rnd *rand.Rand, n, size int) [][]byte {
+	s := make([][]byte, n)
+	for i := range s {
+		s[i] = make([]byte, size)
+		rnd.Read(s[i])
+	}
+	return s
+}
+
+func TestField(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), mul(byte(a), inv(byte(a))), "a=%d", a)
+	}
+	assert.Equal(t, byte(0), mul(0, 7))
+}
+
+func TestReconstruct(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name    string
+		data    int
+		parity  int
+		missing []int
+		wantErr bool
+	}{
+		{name: "nothing missing", data: 4, parity: 2},
+		{name: "one data shard", data: 4, parity: 2, missing: []int{1}},
+		{name: "all parity shards", data: 4, parity: 2, missing: []int{4, 5}},
+		{name: "data and parity shard", data: 4, parity: 2, missing: []int{0, 5}},
+		{name: "K data shards", data: 10, parity: 3, missing: []int{0, 5, 9}},
+		{name: "single data shard", data: 1, parity: 1, missing: []int{0}},
+		{name: "maximum shards", data: 250, parity: 6, missing: []int{0, 1, 100, 200, 249, 255}},
+		{name: "too many missing", data: 4, parity: 2, missing: []int{0, 1, 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := shards(rnd, tt.data, 100)
+			parity := shards(rnd, tt.parity, 100)
+			require.NoError(t, Encode(data, parity))
+			all := append(append([][]byte{}, data...), parity...)
+
+			damaged := make([][]byte, len(all))
+			present := make([]bool, len(all))
+			for i := range all {
+				damaged[i] = append([]byte{}, all[i]...)
+				present[i] = true
+			}
+			for _, i := range tt.missing {
+				rnd.Read(damaged[i])
+				present[i] = false
+			}
+
+			r, err := NewReconstructor(tt.data, present)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrTooFewShards))
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, r.Reconstruct(damaged))
+			for i := range all {
+				assert.True(t, bytes.Equal(all[i], damaged[i]), "shard %d", i)
+			}
+		})
+	}
+}
+
+func TestEncode_Invalid(t *testing.T) {
+	assert.Error(t, Encode(make([][]byte, 200), make([][]byte, 57)))
+	assert.Error(t, Encode([][]byte{{1, 2}}, [][]byte{{1}}))
+	assert.Error(t, Encode(nil, [][]byte{{1}}))
+}
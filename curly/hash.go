@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	hashFormatSum  = "sum"
+	hashFormatTag  = "tag"
+	hashFormatJSON = "json"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"crc32c": func() hash.Hash {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	},
+	"blake2b": func() hash.Hash {
+		// error is returned only for too long key
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+}
+
+// parseHashes parses comma separated list of hash algorithms,
+// e.g. "sha256,blake2b".
+func parseHashes(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := hashAlgorithms[name]; !ok {
+			return nil, fmt.Errorf("unsupported hash %q, supported: %s", name, strings.Join(supportedHashes(), ","))
+		}
+		names = appendHash(names, name)
+	}
+	return names, nil
+}
+
+// appendHash appends name to names unless it is already there.
+func appendHash(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+func supportedHashes() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var _ io.Writer = (*digests)(nil)
+
+// digests computes several hashes of one stream in a single pass.
+type digests struct {
+	names  []string
+	hashes []hash.Hash
+}
+
+func newDigests(names []string) *digests {
+	d := &digests{names: names}
+	for _, name := range names {
+		d.hashes = append(d.hashes, hashAlgorithms[name]())
+	}
+	return d
+}
+
+func (d *digests) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Select returns digests of given algorithms, hashes are shared with d.
+func (d *digests) Select(names []string) *digests {
+	s := &digests{}
+	for i, n := range d.names {
+		for _, name := range names {
+			if n == name {
+				s.names = append(s.names, n)
+				s.hashes = append(s.hashes, d.hashes[i])
+			}
+		}
+	}
+	return s
+}
+
+// Sum returns hex encoded digest of given hash algorithm.
+func (d *digests) Sum(name string) (string, bool) {
+	for i, n := range d.names {
+		if n == name {
+			return fmt.Sprintf("%x", d.hashes[i].Sum(nil)), true
+		}
+	}
+	return "", false
+}
+
+// Print writes digests of file in one of formats:
+//
+//	sum:  "<hex>  file" line per algorithm, same as sha256sum output
+//	tag:  "SHA256 (file) = <hex>" line per algorithm, same as sha256sum --tag
+//	json: single object with file name and digest per algorithm
+func (d *digests) Print(w io.Writer, format string, file string) error {
+	switch format {
+	case hashFormatSum, hashFormatTag:
+		for _, name := range d.names {
+			sum, _ := d.Sum(name)
+			line := fmt.Sprintf("%s  %s\n", sum, file)
+			if format == hashFormatTag {
+				line = fmt.Sprintf("%s (%s) = %s\n", hashTag(name), file, sum)
+			}
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case hashFormatJSON:
+		out := map[string]string{"file": file}
+		for _, name := range d.names {
+			out[name], _ = d.Sum(name)
+		}
+		return json.NewEncoder(w).Encode(out)
+	default:
+		return fmt.Errorf("unsupported hash format %q", format)
+	}
+}
+
+// hashTag returns algorithm name used by coreutils --tag output.
+func hashTag(name string) string {
+	if name == "blake2b" {
+		return "BLAKE2b"
+	}
+	return strings.ToUpper(name)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigests(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		want string
+	}{
+		{name: "md5", hash: "md5", want: "25f9e794323b453885f5181f1b624d0b"},
+		{name: "sha1", hash: "sha1", want: "f7c3bc1d808e04732adf679965ccc34ca7ae3441"},
+		{name: "sha256", hash: "sha256", want: "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225"},
+		{name: "sha512", hash: "sha512", want: "d9e6762dd1c8eaf6d61b3c6192fc408d4d6d5f1176d0c29169bc24e71c3f274ad27fcd5811b313d681f7e55ec02d73d499c95455b6b5bb503acf574fba8ffe85"},
+		{name: "crc32c", hash: "crc32c", want: "e3069283"},
+		{name: "blake2b", hash: "blake2b", want: "f5ab8bafa6f2f72b431188ac38ae2de7bb618fb3d38b6cbf639defcdd5e10a86b22fccff571da37e42b23b80b657ee4d936478f582280a87d6dbb1da73f5c47d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := parseHashes(tt.hash)
+			require.NoError(t, err)
+			d := newDigests(names)
+			_, err = io.Copy(d, strings.NewReader("123456789"))
+			require.NoError(t, err)
+
+			got, ok := d.Sum(tt.hash)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseHashes(t *testing.T) {
+	names, err := parseHashes("SHA256, md5,,sha256")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sha256", "md5"}, names)
+
+	_, err = parseHashes("sha256,whirlpool")
+	assert.Error(t, err)
+}
+
+func TestDigests_Print(t *testing.T) {
+	d := newDigests([]string{"md5", "crc32c"})
+	_, err := io.WriteString(d, "123456789")
+	require.NoError(t, err)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: hashFormatSum,
+			want:   "25f9e794323b453885f5181f1b624d0b  file\ne3069283  file\n",
+		},
+		{
+			format: hashFormatTag,
+			want:   "MD5 (file) = 25f9e794323b453885f5181f1b624d0b\nCRC32C (file) = e3069283\n",
+		},
+		{
+			format: hashFormatJSON,
+			want:   `{"crc32c":"e3069283","file":"file","md5":"25f9e794323b453885f5181f1b624d0b"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, d.Print(&buf, tt.format, "file"))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	contentTypeForm = "application/x-www-form-urlencoded"
+	contentTypeJSON = "application/json"
+)
+
+// requestFlags are flags which customize download request: method, headers
+// and body.
+type requestFlags struct {
+	method string
+	header http.Header
+	data   []dataValue
+	json   []dataValue
+	form   []string
+}
+
+// dataValue is value of -d, -data-binary or -json flag, binary values are sent
+// as they are.
+type dataValue struct {
+	value  string
+	binary bool
+}
+
+func (f *requestFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.method, "X", "", "request METHOD, default is GET or POST when request has body")
+	fs.Func("H", "request header 'Name: value', can be repeated, 'Name:' removes header and 'Name;' sends empty header", f.addHeader)
+	fs.Func("d", "request body, @FILE reads FILE without new lines, @- reads stdin, repeated values are joined by &", func(v string) error {
+		f.data = append(f.data, dataValue{value: v})
+		return nil
+	})
+	fs.Func("data-binary", "request body sent as is, @FILE reads FILE, @- reads stdin", func(v string) error {
+		f.data = append(f.data, dataValue{value: v, binary: true})
+		return nil
+	})
+	fs.Func("F", "multipart form field name=value or name=@FILE[;type=MIME], can be repeated", func(v string) error {
+		f.form = append(f.form, v)
+		return nil
+	})
+	fs.Func("json", "JSON request body, @FILE reads FILE, @- reads stdin, sets Content-Type and Accept to "+contentTypeJSON, func(v string) error {
+		f.json = append(f.json, dataValue{value: v, binary: true})
+		return nil
+	})
+}
+
+// addHeader parses 'Name: value' header.
+func (f *requestFlags) addHeader(v string) error {
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	// 'Name;' is header with empty value, as in curl
+	if strings.HasSuffix(v, ";") && !strings.Contains(v, ":") {
+		f.header.Add(strings.TrimSpace(strings.TrimSuffix(v, ";")), "")
+		return nil
+	}
+	i := strings.IndexByte(v, ':')
+	if i <= 0 {
+		return fmt.Errorf("header %q must be in format 'Name: value'", v)
+	}
+	name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(v[:i]))
+	value := strings.TrimSpace(v[i+1:])
+	if value == "" {
+		f.header[name] = nil
+		return nil
+	}
+	f.header.Add(name, value)
+	return nil
+}
+
+// apply sets method, header and body of download request in cfg. Method is
+// GET, or POST when request has body, unless -X is specified.
+func (f *requestFlags) apply(cfg *Config) error {
+	body, err := f.body()
+	if err != nil {
+		return err
+	}
+	cfg.Method, cfg.Header, cfg.Body = f.method, f.header, body
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+		if body != nil {
+			cfg.Method = http.MethodPost
+		}
+	}
+	return nil
+}
+
+// body returns request body specified by flags, nil body is returned when
+// there is none.
+func (f *requestFlags) body() (*requestBody, error) {
+	switch {
+	case len(f.form) > 0 && (len(f.data) > 0 || len(f.json) > 0):
+		return nil, fmt.Errorf("-F can not be combined with -d, -data-binary or -json")
+	case len(f.json) > 0 && len(f.data) > 0:
+		return nil, fmt.Errorf("-json can not be combined with -d or -data-binary")
+	case len(f.form) > 0:
+		return newFormBody(f.form)
+	case len(f.json) > 0:
+		if _, ok := f.header["Accept"]; !ok {
+			if f.header == nil {
+				f.header = make(http.Header)
+			}
+			f.header.Set("Accept", contentTypeJSON)
+		}
+		return newDataBody(f.json, "", contentTypeJSON)
+	case len(f.data) > 0:
+		return newDataBody(f.data, "&", contentTypeForm)
+	default:
+		return nil, nil
+	}
+}
+
+// requestBody is body of download request, open is called for every sent
+// request so the body can be sent again on retry or by batch download.
+type requestBody struct {
+	contentType string
+	// size is -1 if it is not known
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// newDataBody joins values by sep. Values starting with @ are read from file
+// or stdin, single binary @FILE value is streamed from the file.
+func newDataBody(values []dataValue, sep, contentType string) (*requestBody, error) {
+	if len(values) == 1 && values[0].binary && strings.HasPrefix(values[0].value, "@") && values[0].value != "@-" {
+		name := values[0].value[1:]
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read request body: %w", err)
+		}
+		return &requestBody{
+			contentType: contentType,
+			size:        fi.Size(),
+			open: func() (io.ReadCloser, error) {
+				return os.Open(name)
+			},
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		b, err := readDataValue(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	b := buf.Bytes()
+	return &requestBody{
+		contentType: contentType,
+		size:        int64(len(b)),
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		},
+	}, nil
+}
+
+func readDataValue(v dataValue) ([]byte, error) {
+	if !strings.HasPrefix(v.value, "@") {
+		return []byte(v.value), nil
+	}
+	var b []byte
+	var err error
+	if v.value == "@-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(v.value[1:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request body: %w", err)
+	}
+	if !v.binary {
+		// -d strips new lines of files as curl does
+		b = bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r"), nil), []byte("\n"), nil)
+	}
+	return b, nil
+}
+
+// formField is field of -F multipart form, file fields have file name.
+type formField struct {
+	name        string
+	value       string
+	file        string
+	contentType string
+}
+
+func parseFormField(v string) (formField, error) {
+	i := strings.IndexByte(v, '=')
+	if i <= 0 {
+		return formField{}, fmt.Errorf("form field %q must be in format name=value or name=@FILE", v)
+	}
+	field := formField{name: v[:i], value: v[i+1:]}
+	if !strings.HasPrefix(field.value, "@") {
+		return field, nil
+	}
+	field.file, field.value = field.value[1:], ""
+	if j := strings.Index(field.file, ";type="); j >= 0 {
+		field.file, field.contentType = field.file[:j], field.file[j+len(";type="):]
+	}
+	if _, err := os.Stat(field.file); err != nil {
+		return formField{}, fmt.Errorf("unable to read form field %s: %w", field.name, err)
+	}
+	return field, nil
+}
+
+// newFormBody returns multipart/form-data body, files are streamed when the
+// body is sent.
+func newFormBody(values []string) (*requestBody, error) {
+	fields := make([]formField, len(values))
+	for i, v := range values {
+		var err error
+		if fields[i], err = parseFormField(v); err != nil {
+			return nil, err
+		}
+	}
+	// boundary is part of content type so it must not change between requests
+	boundary := multipart.NewWriter(nil).Boundary()
+	return &requestBody{
+		contentType: "multipart/form-data; boundary=" + boundary,
+		size:        -1,
+		open: func() (io.ReadCloser, error) {
+			pipeR, pipeW := io.Pipe()
+			go func() {
+				pipeW.CloseWithError(writeForm(pipeW, boundary, fields))
+			}()
+			return pipeR, nil
+		},
+	}, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func writeForm(w io.Writer, boundary string, fields []formField) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field.file == "" {
+			if err := mw.WriteField(field.name, field.value); err != nil {
+				return err
+			}
+			continue
+		}
+		contentType := field.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(field.name), quoteEscaper.Replace(filepath.Base(field.file))))
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(part, field.file); err != nil {
+			return fmt.Errorf("unable to send form field %s: %w", field.name, err)
+		}
+	}
+	return mw.Close()
+}
+
+func copyFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// newDownloadRequest returns request of cfg.DownloadURL with -X method, -H
+// headers and body.
+func newDownloadRequest(ctx context.Context, cfg *Config) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, cfg.DownloadURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if b := cfg.Body; b != nil {
+		if req.Body, err = b.open(); err != nil {
+			return nil, fmt.Errorf("unable to open request body: %w", err)
+		}
+		req.GetBody = b.open
+		req.ContentLength = b.size
+		if b.size == 0 {
+			req.Body.Close()
+			req.Body, req.GetBody = http.NoBody, nil
+		}
+		req.Header.Set("Content-Type", b.contentType)
+	}
+	setHeader(req, cfg.Header)
+	return req, nil
+}
+
+// setHeader sets -H headers h on request, header without values is removed.
+func setHeader(req *http.Request, h http.Header) {
+	for name, values := range h {
+		switch {
+		case name == "Host" && len(values) > 0:
+			req.Host = values[0]
+		case name == "User-Agent" && len(values) == 0:
+			// empty User-Agent is not sent, default is used when it is missing
+			req.Header.Set(name, "")
+		case len(values) == 0:
+			req.Header.Del(name)
+		default:
+			req.Header[name] = values
+		}
+	}
+}
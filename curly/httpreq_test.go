@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// echoRequest is request received by echo server.
+type echoRequest struct {
+	method string
+	host   string
+	header http.Header
+	body   string
+	form   map[string]string
+}
+
+func newEchoServer(t *testing.T, got *echoRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = echoRequest{method: r.Method, host: r.Host, header: r.Header}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if !assert.NoError(t, r.ParseMultipartForm(1<<20)) {
+				return
+			}
+			got.form = make(map[string]string)
+			for name, values := range r.MultipartForm.Value {
+				got.form[name] = values[0]
+			}
+			for name, files := range r.MultipartForm.File {
+				f, err := files[0].Open()
+				if !assert.NoError(t, err) {
+					return
+				}
+				b, _ := io.ReadAll(f)
+				f.Close()
+				got.form[name] = files[0].Filename + " " + files[0].Header.Get("Content-Type") + " " + string(b)
+			}
+		} else {
+			b, err := io.ReadAll(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got.body = string(b)
+		}
+		io.WriteString(w, "response of "+r.Method)
+	}))
+}
+
+func TestRequestFlags(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data.txt")
+	require.NoError(t, os.WriteFile(data, []byte("b=2\nc=3\n"), 0o644))
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantMethod string
+		wantBody   string
+		wantHeader map[string]string
+		wantAbsent []string
+		wantForm   map[string]string
+	}{
+		{
+			name:       "get",
+			wantMethod: http.MethodGet,
+		},
+		{
+			name:       "data",
+			args:       []string{"-d", "a=1", "-d", "@" + data},
+			wantMethod: http.MethodPost,
+			wantBody:   "a=1&b=2c=3",
+			wantHeader: map[string]string{"Content-Type": contentTypeForm},
+		},
+		{
+			name:       "data binary file",
+			args:       []string{"-X", "PUT", "-data-binary", "@" + data, "-H", "Content-Type: text/plain"},
+			wantMethod: http.MethodPut,
+			wantBody:   "b=2\nc=3\n",
+			wantHeader: map[string]string{"Content-Type": "text/plain", "Content-Length": "8"},
+		},
+		{
+			name:       "data binary stdin",
+			args:       []string{"-data-binary", "@-"},
+			stdin:      "from\nstdin",
+			wantMethod: http.MethodPost,
+			wantBody:   "from\nstdin",
+		},
+		{
+			name:       "json",
+			args:       []string{"-json", `{"a":1}`},
+			wantMethod: http.MethodPost,
+			wantBody:   `{"a":1}`,
+			wantHeader: map[string]string{"Content-Type": contentTypeJSON, "Accept": contentTypeJSON},
+		},
+		{
+			name:       "headers",
+			args:       []string{"-X", "DELETE", "-H", "Authorization: Bearer token", "-H", "x-empty;", "-H", "User-Agent:", "-H", "Host: example.com"},
+			wantMethod: http.MethodDelete,
+			wantHeader: map[string]string{"Authorization": "Bearer token", "X-Empty": "", "Host": "example.com"},
+			wantAbsent: []string{"User-Agent"},
+		},
+		{
+			name:       "form",
+			args:       []string{"-F", "name=value", "-F", "file=@" + data + ";type=text/plain"},
+			wantMethod: http.MethodPost,
+			wantForm:   map[string]string{"name": "value", "file": "data.txt text/plain b=2\nc=3\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin = strings.NewReader(tt.stdin)
+			defer func() { stdin = os.Stdin }()
+
+			var got echoRequest
+			ts := newEchoServer(t, &got)
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			require.NoError(t, err)
+
+			var rf requestFlags
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			rf.register(fs)
+			require.NoError(t, fs.Parse(tt.args))
+			cfg := &Config{DownloadURL: u}
+			require.NoError(t, rf.apply(cfg))
+
+			// second request checks that body can be sent again
+			for i := 0; i < 2; i++ {
+				req, err := newDownloadRequest(context.Background(), cfg)
+				require.NoError(t, err)
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				assert.Equal(t, tt.wantMethod, got.method)
+				assert.Equal(t, tt.wantBody, got.body)
+				for name, value := range tt.wantHeader {
+					if name == "Host" {
+						assert.Equal(t, value, got.host)
+						continue
+					}
+					assert.Contains(t, got.header, name)
+					assert.Equal(t, value, got.header.Get(name), name)
+				}
+				for _, name := range tt.wantAbsent {
+					assert.NotContains(t, got.header, name)
+				}
+				assert.Equal(t, tt.wantForm, got.form)
+			}
+		})
+	}
+}
+
+func TestRequestFlags_Invalid(t *testing.T) {
+	tests := [][]string{
+		{"-H", "no colon"},
+		{"-d", "a=1", "-F", "b=2"},
+		{"-d", "a=1", "-json", "{}"},
+		{"-F", "novalue"},
+		{"-F", "file=@missing"},
+		{"-data-binary", "@missing"},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			var rf requestFlags
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			rf.register(fs)
+			err := fs.Parse(args)
+			if err == nil {
+				err = rf.apply(&Config{})
+			}
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDownload_Post(t *testing.T) {
+	var got echoRequest
+	ts := newEchoServer(t, &got)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cfg := &Config{
+		DownloadURL:   u,
+		Method:        http.MethodPost,
+		Body:          &requestBody{contentType: contentTypeJSON, size: 2, open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("{}")), nil }},
+		Output:        filepath.Join(dir, "out"),
+		ChunkedPrefix: filepath.Join(dir, "chunk"),
+		ChunkSize:     4,
+		Hashes:        []string{"md5"},
+		HashFormat:    hashFormatSum,
+		Progress:      progressNone,
+	}
+	n, err := download(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("response of POST")), n)
+	assert.Equal(t, "{}", got.body)
+
+	b, err := os.ReadFile(cfg.Output)
+	require.NoError(t, err)
+	assert.Equal(t, "response of POST", string(b))
+	b, err = os.ReadFile(cfg.ChunkedPrefix + ".0")
+	require.NoError(t, err)
+	assert.Equal(t, "resp", string(b))
+}
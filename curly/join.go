@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
+)
+
+type chunkFile struct {
+	name        string
+	idx         int
+	size        int64
+	compression string
+	encrypted   bool
+}
+
+// findChunks returns all FILEPREFIX.N files ordered by numeric index N.
+// Compressed chunks FILEPREFIX.N.gz etc. and encrypted chunks FILEPREFIX.N.enc
+// are found as well, all chunks must use the same compression and encryption.
+// It fails if any index between 0 and the highest found index is missing or
+// if more files have the same index, e.g. FILEPREFIX.0 and FILEPREFIX.0.gz.
+func findChunks(prefix string) ([]chunkFile, error) {
+	dir, base := filepath.Split(prefix)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read chunks directory: %w", err)
+	}
+
+	var chunks []chunkFile
+	for _, e := range entries {
+		suffix := strings.TrimPrefix(e.Name(), base+".")
+		if suffix == e.Name() || e.IsDir() {
+			continue
+		}
+		encrypted := strings.HasSuffix(suffix, encryptedExt)
+		suffix = strings.TrimSuffix(suffix, encryptedExt)
+		compression := ""
+		for algo, ext := range compressExt {
+			if strings.HasSuffix(suffix, ext) {
+				compression = algo
+				suffix = strings.TrimSuffix(suffix, ext)
+			}
+		}
+		idx, ok := chunkIndex(suffix)
+		if !ok {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat chunk: %w", err)
+		}
+		chunks = append(chunks, chunkFile{
+			name:        filepath.Join(dir, e.Name()),
+			idx:         idx,
+			size:        fi.Size(),
+			compression: compression,
+			encrypted:   encrypted,
+		})
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks found for prefix %s", prefix)
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].idx < chunks[j].idx
+	})
+	for i, c := range chunks {
+		if i > 0 && c.idx == chunks[i-1].idx {
+			return nil, fmt.Errorf("chunks %s and %s have the same index %d", chunks[i-1].name, c.name, c.idx)
+		}
+		if c.idx != i {
+			return nil, fmt.Errorf("missing chunk %s", filename(prefix, i)+chunkExt(c.compression, c.encrypted))
+		}
+		if c.compression != chunks[0].compression {
+			return nil, fmt.Errorf("chunks %s and %s use different compression", chunks[0].name, c.name)
+		}
+		if c.encrypted != chunks[0].encrypted {
+			return nil, fmt.Errorf("chunks %s and %s differ in encryption", chunks[0].name, c.name)
+		}
+	}
+	return chunks, nil
+}
+
+// chunkIndex parses decimal chunk index, signs and leading zeros are not
+// allowed so "01" is not mistaken for chunk 1.
+func chunkIndex(s string) (int, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.Atoi(s)
+	return idx, err == nil
+}
+
+// joinChunks streams chunks of prefix into w. All chunks except the last one
+// must have chunkSize bytes, or one byte less as chunks of -chunks are split
+// evenly with the larger chunks first, otherwise the chunk is considered
+// truncated.
+// Size of compressed chunks is not checked, they are only decompressed.
+// Encrypted chunks are decrypted by secret s and verified.
+// It is used when chunks have no manifest, content can not be verified.
+func joinChunks(w io.Writer, prefix string, chunkSize int64, s *encrypt.Secret) error {
+	chunks, err := findChunks(prefix)
+	if err != nil {
+		return err
+	}
+	codec := chunkCodec{compression: chunks[0].compression}
+	if chunks[0].encrypted {
+		if s == nil {
+			return errNoSecret
+		}
+		codec.secret = s
+	}
+	fileSize := func(size int64) int64 {
+		if chunks[0].encrypted {
+			return size + encrypt.Overhead(size)
+		}
+		return size
+	}
+	// smaller is the first chunk one byte smaller than chunkSize, full chunk
+	// after it means it is truncated
+	full := fileSize(chunkSize)
+	var smaller *chunkFile
+	for i := range chunks {
+		c := &chunks[i]
+		if c.compression != "" {
+			break
+		}
+		last := i == len(chunks)-1
+		switch {
+		case c.size > full:
+			return fmt.Errorf("chunk %s is larger than chunk size %d", c.name, chunkSize)
+		case c.size == full && smaller != nil:
+			return fmt.Errorf("chunk %s is truncated: got %d bytes, expected %d", smaller.name, smaller.size, full)
+		case c.size == full || last:
+		case c.size == fileSize(chunkSize-1) && chunkSize > 1:
+			if smaller == nil {
+				smaller = c
+			}
+		default:
+			return fmt.Errorf("chunk %s is truncated: got %d bytes, expected %d", c.name, c.size, full)
+		}
+	}
+
+	for _, c := range chunks {
+		if err := codec.copy(w, c.name, c.idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinManifest streams chunks listed in manifest m into w. Size and hash of
+// every chunk and hash of the whole file are verified. Compressed chunks are
+// decompressed, encrypted chunks are decrypted by secret s, their size and hash
+// are verified after decompression and decryption.
+func joinManifest(w io.Writer, prefix string, m *Manifest, s *encrypt.Secret) error {
+	codec := chunkCodec{compression: m.Compression}
+	if m.Encrypted {
+		if s == nil {
+			return errNoSecret
+		}
+		codec.secret = s
+	}
+	dir := filepath.Dir(prefix)
+	for _, c := range m.Chunks {
+		name := filepath.Join(dir, c.Name)
+		fi, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("missing chunk %s", name)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to stat chunk: %w", err)
+		}
+		fileSize := c.Size
+		if m.Encrypted {
+			fileSize += encrypt.Overhead(c.Size)
+		}
+		if m.Compression == "" && fi.Size() != fileSize {
+			return fmt.Errorf("chunk %s is truncated: got %d bytes, expected %d", name, fi.Size(), fileSize)
+		}
+	}
+
+	fileH := sha256.New()
+	w = io.MultiWriter(w, fileH)
+	for i, c := range m.Chunks {
+		name := filepath.Join(dir, c.Name)
+		chunkH := sha256.New()
+		counter := &countingWriter{w: io.MultiWriter(w, chunkH)}
+		if err := codec.copy(counter, name, i); err != nil {
+			return err
+		}
+		if counter.n != c.Size {
+			return fmt.Errorf("chunk %s is truncated: got %d bytes, expected %d", name, counter.n, c.Size)
+		}
+		if err := verifyHash(c.Hash, chunkH); err != nil {
+			return fmt.Errorf("chunk %s is corrupted: %w", name, err)
+		}
+	}
+	if err := verifyHash(m.Hash, fileH); err != nil {
+		return fmt.Errorf("joined file is corrupted: %w", err)
+	}
+	return nil
+}
+
+func verifyHash(want string, h hash.Hash) error {
+	wantSum, err := parseHash(want)
+	if err != nil {
+		return err
+	}
+	if sum := h.Sum(nil); !bytes.Equal(wantSum, sum) {
+		return fmt.Errorf("checksum mismatch: expected %x, got %x", wantSum, sum)
+	}
+	return nil
+}
+
+// errNoSecret is returned when encrypted chunks are joined without a key.
+var errNoSecret = fmt.Errorf("chunks are encrypted, use -key-file, -passphrase-file or %s env", passphraseEnv)
+
+// chunkCodec decodes content of chunk files.
+type chunkCodec struct {
+	compression string
+	// secret decrypts chunks, chunks are not encrypted if it is nil.
+	secret *encrypt.Secret
+}
+
+// copy copies content of chunk idx into w, chunk is decrypted and
+// decompressed according to the codec.
+func (c chunkCodec) copy(w io.Writer, name string, idx int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("unable to open chunk: %w", err)
+	}
+	defer f.Close()
+
+	r := io.Reader(f)
+	if c.secret != nil {
+		if r, err = encrypt.NewReader(r, c.secret, chunkAD(idx)); err != nil {
+			return fmt.Errorf("unable to decrypt chunk %s: %w", name, err)
+		}
+	}
+	if c.compression != "" {
+		d, err := newDecompressor(c.compression, r)
+		if err != nil {
+			return fmt.Errorf("unable to decompress chunk %s: %w", name, err)
+		}
+		defer d.Close()
+		r = d
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("unable to copy chunk %s: %w", name, err)
+	}
+	return nil
+}
+
+// runJoin implements `curly join [flags] FILEPREFIX` which reassembles chunks
+// written by -output-chunked. Chunks are verified against FILEPREFIX.manifest.json
+// if it exists, encrypted manifest FILEPREFIX.manifest.json.enc is used when
+// the key is specified. Missing or damaged chunks are rebuilt from parity chunks
+// if the manifest lists them. Output file is removed when chunks can not be joined.
+func runJoin(log *zap.SugaredLogger, args []string) (err error) {
+	fs := flag.NewFlagSet("join", flag.ContinueOnError)
+	output := fs.String("output", "-", "joined file, if value is '-' output is stdout")
+	chunkSize := int64(floppySize)
+	fs.Func("chunk-size", "size of chunks, e.g. 500KiB, 100MB or preset "+strings.Join(sizePresetNames(), ",")+", used only when manifest is missing", func(sizeFlag string) error {
+		size, err := parseSize(sizeFlag)
+		if err != nil {
+			return err
+		}
+		chunkSize = size
+		return nil
+	})
+	var sf secretFlags
+	sf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: curly join [flags] FILEPREFIX")
+	}
+	prefix := fs.Arg(0)
+	secret, err := sf.secret()
+	if err != nil {
+		return err
+	}
+
+	m, err := loadManifest(manifestFilename(prefix))
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		if _, statErr := os.Stat(encryptedManifestFilename(prefix)); statErr == nil && secret == nil {
+			return errNoSecret
+		}
+		if secret != nil {
+			if m, err = loadEncryptedManifest(encryptedManifestFilename(prefix), secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	w := io.Writer(stdout)
+	if *output != "-" {
+		f, createErr := os.Create(*output)
+		if createErr != nil {
+			return fmt.Errorf("unable to create os file: %w", createErr)
+		}
+		defer func() {
+			f.Close()
+			if err != nil {
+				os.Remove(*output)
+			}
+		}()
+		w = f
+	}
+
+	if m == nil {
+		log.Warnf("manifest %s not found, joined file is not verified", manifestFilename(prefix))
+		return joinChunks(w, prefix, chunkSize, secret)
+	}
+	if m.Parity != nil {
+		if err := repairChunks(log, prefix, m); err != nil {
+			return err
+		}
+	}
+	if err := joinManifest(w, prefix, m, secret); err != nil {
+		return err
+	}
+	log.Debugf("chunks joined and verified, %s", m.Hash)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func writeChunks(t *testing.T, prefix string, content []byte, chunkSize int64) {
+	chunker, err := NewFileChunker(prefix)
+	require.NoError(t, err)
+	mc := newManifestChunker(chunker, "http://example.com/foo", chunkSize)
+	chunked, err := NewChunked(mc, chunkSize)
+	require.NoError(t, err)
+	_, err = chunked.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, mc.Close())
+	require.NoError(t, mc.Manifest().save(manifestFilename(prefix)))
+}
+
+func TestJoinChunks(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	tests := []struct {
+		name    string
+		modify  func(prefix string)
+		wantErr bool
+	}{
+		{
+			name:   "more than 10 chunks",
+			modify: func(prefix string) {},
+		},
+		{
+			name: "missing chunk",
+			modify: func(prefix string) {
+				os.Remove(filename(prefix, 4))
+			},
+			wantErr: true,
+		},
+		{
+			name: "truncated chunk",
+			modify: func(prefix string) {
+				os.WriteFile(filename(prefix, 2), []byte("ab"), 0o644)
+			},
+			wantErr: true,
+		},
+		{
+			name: "corrupted chunk",
+			modify: func(prefix string) {
+				os.WriteFile(filename(prefix, 2), []byte("XXX"), 0o644)
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing manifest",
+			modify: func(prefix string) {
+				os.Remove(manifestFilename(prefix))
+			},
+		},
+		{
+			name: "missing manifest truncated chunk",
+			modify: func(prefix string) {
+				os.Remove(manifestFilename(prefix))
+				os.WriteFile(filename(prefix, 2), []byte("ab"), 0o644)
+			},
+			wantErr: true,
+		},
+		{
+			name: "chunk outside of manifest directory",
+			modify: func(prefix string) {
+				m, _ := loadManifest(manifestFilename(prefix))
+				os.Rename(filename(prefix, 0), filepath.Join(filepath.Dir(prefix), "..", "foo.0"))
+				m.Chunks[0].Name = "../foo.0"
+				m.save(manifestFilename(prefix))
+			},
+			wantErr: true,
+		},
+		{
+			name: "unrelated files are ignored",
+			modify: func(prefix string) {
+				os.WriteFile(prefix+".01", []byte("X"), 0o644)
+				os.WriteFile(prefix+".tmp", []byte("X"), 0o644)
+				os.WriteFile(prefix+"x.20", []byte("X"), 0o644)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "foo")
+			writeChunks(t, prefix, content, 3)
+			tt.modify(prefix)
+
+			output := filepath.Join(t.TempDir(), "joined")
+			err := runJoin(zap.NewNop().Sugar(), []string{"-chunk-size", "3", "-output", output, prefix})
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.NoFileExists(t, output)
+				return
+			}
+			require.NoError(t, err)
+			got, err := os.ReadFile(output)
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+		})
+	}
+}
+
+func TestFindChunks_Order(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "foo")
+	writeChunks(t, prefix, bytes.Repeat([]byte("a"), 23), 2)
+
+	chunks, err := findChunks(prefix)
+	require.NoError(t, err)
+	require.Len(t, chunks, 12)
+	for i, c := range chunks {
+		assert.Equal(t, filename(prefix, i), c.name)
+	}
+}
+
+func TestJoinChunks_Even(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	content = append(content, 'x')
+
+	tests := []struct {
+		name    string
+		modify  func(prefix string)
+		wantErr string
+	}{
+		{name: "sizes differ by one byte", modify: func(prefix string) {}},
+		{
+			name: "truncated smaller chunk",
+			modify: func(prefix string) {
+				os.WriteFile(filename(prefix, 1), bytes.Repeat([]byte("a"), 24), 0o644)
+			},
+			wantErr: "is truncated",
+		},
+		{
+			name: "full chunk after smaller one",
+			modify: func(prefix string) {
+				os.WriteFile(filename(prefix, 2), bytes.Repeat([]byte("a"), 26), 0o644)
+			},
+			wantErr: "foo.1 is truncated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "foo")
+			chunker, err := NewFileChunker(prefix)
+			require.NoError(t, err)
+			chunked, err := NewEvenChunked(chunker, int64(len(content)), 4)
+			require.NoError(t, err)
+			_, err = chunked.Write(content)
+			require.NoError(t, err)
+			require.NoError(t, chunker.Close())
+			tt.modify(prefix)
+
+			var got bytes.Buffer
+			err = joinChunks(&got, prefix, 26, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, content, got.Bytes())
+		})
+	}
+}
+
+func TestFindChunks_DuplicateIndex(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "foo")
+	writeChunks(t, prefix, []byte("0123456789"), 4)
+	require.NoError(t, os.WriteFile(filename(prefix, 0)+".gz", []byte("x"), 0o644))
+
+	_, err := findChunks(prefix)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "have the same index 0")
+}
@@ -2,19 +2,27 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/adamplansky/go-bridge-mentoring/curly/cookiejar"
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
+	"github.com/adamplansky/go-bridge-mentoring/curly/ratelimit"
 	"github.com/adamplansky/go-bridge-mentoring/curly/request"
 
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
 	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
@@ -23,48 +31,166 @@ import (
 const (
 	// floppy disk size = 1.44 * 1000 * 1024
 	floppySize = 1_474_560
+
+	// exitChecksumMismatch is exit code when downloaded content does not match
+	// -checksum, other errors exit with 1
+	exitChecksumMismatch = 3
 )
 
 var (
-	stdout  = os.Stdout
-	stdnull = io.Discard
+	stdin   io.Reader = os.Stdin
+	stdout            = os.Stdout
+	stdnull           = io.Discard
 )
 
 type Config struct {
 	MD5           bool
+	Hashes        []string
+	HashFormat    string
+	Checksum      *checksum
+	ChecksumFile  string
 	ChunkedPrefix string
+	ChunkSize     int64
+	Chunks        int
+	Parity        int
+	ChunkCompress string
+	Output        string
+	Continue      bool
+	Segments      int
 	Std           io.Writer
 	DownloadURL   *url.URL
 	Upload        bool
 	UploadURL     *url.URL
 	Verbose       bool
+	Retry         int
+	RetryMaxTime  time.Duration
+	// MaxTime limits whole download, zero means no limit. ConnectTimeout
+	// limits dial and TLS handshake of every connection.
+	MaxTime        time.Duration
+	ConnectTimeout time.Duration
+	// LimitRate and UploadLimitRate are in bytes per second, zero means
+	// no limit.
+	LimitRate       int64
+	UploadLimitRate int64
+	// Progress is format of progress on stderr: bar, json or none.
+	Progress string
+	// UploadProtocol is multipart form POST or resumable tus upload.
+	UploadProtocol string
+	// S3 and S3PartSize configure upload to s3://bucket/key UploadURL, zero
+	// S3PartSize is default of request.S3Uploader.
+	S3         request.S3Options
+	S3PartSize int
+
+	// Proxy is used by download and upload requests, proxy from environment
+	// is used when it is nil. NoProxy hosts are never proxied.
+	Proxy   *url.URL
+	NoProxy []string
+	// TLS configures verification of server and client certificate, default
+	// config is used when it is nil.
+	TLS *tls.Config
+
+	// Method, Header and Body customize download request, Header is sent by
+	// -segments and -continue range requests as well.
+	Method string
+	Header http.Header
+	Body   *requestBody
+
+	// Compressed requests compressed content which is decoded before output,
+	// chunks and hashes, Raw keeps it encoded.
+	Compressed bool
+	Raw        bool
+
+	// HAR records requests of client created by newClient, it is saved to
+	// HARFile with first HARBodySize bytes of bodies. Credentials are
+	// redacted unless HARCredentials is set.
+	HARFile        string
+	HARBodySize    int
+	HARCredentials bool
+	HAR            *roundtripper.HAR
+
+	// Recorder saves exchanges of client created by newClient into RecordFile
+	// cassette, Replayer answers them from ReplayFile cassette instead of
+	// network. Credentials are redacted unless RecordCredentials is set.
+	RecordFile        string
+	RecordCredentials bool
+	ReplayFile        string
+	Recorder          *roundtripper.Recorder
+	Replayer          *roundtripper.Replayer
+
+	// CacheDir stores responses of GET requests, CacheMaxSize limits size of
+	// stored bodies, zero means no limit. CacheBypass does not use stored
+	// responses and CachePurge removes them first.
+	CacheDir     string
+	CacheMaxSize int64
+	CacheBypass  bool
+	CachePurge   bool
+
+	// Jar is loaded from CookieJar file and saved to it after download, it is
+	// nil when there are no cookies.
+	CookieJar string
+	Jar       *cookiejar.Jar
+
+	// Batch lists URLs when more URLs or -input-file are given, DownloadURL
+	// is nil then.
+	InputFile string
+	Parallel  int
+	Batch     []batchItem
+
+	// CompressedChunkSize applies ChunkSize to compressed chunks instead
+	// of the raw content.
+	CompressedChunkSize bool
+
+	// Secret encrypts chunks, manifest and upload when -encrypt is set.
+	Encrypt bool
+	Secret  *encrypt.Secret
 }
 
 // https://github.com/mayth/go-simple-upload-server
 func ParseConfig(log *zap.SugaredLogger) (*Config, error) {
 
+	var chunkSizeSet bool
 	cfg := Config{
 		// default value to prevent panic nil std.writer
-		Std: stdnull,
+		Std:       stdnull,
+		ChunkSize: floppySize,
 	}
-	flag.Func("output", "output is downloaded to file, if value is '-' output is stdout, if output is not specified file is printed to /dev/null", func(outputFlag string) error {
-		switch {
-		case outputFlag == "-":
-			cfg.Std = stdout
-		case len(outputFlag) > 0:
-			f, err := os.Create(outputFlag)
-			if err != nil {
-				return fmt.Errorf("unable to create os file: %w", err)
-			}
-			cfg.Std = f
-		default:
-			cfg.Std = stdnull
+	flag.StringVar(&cfg.Output, "output", "", "output is downloaded to file, if value is '-' output is stdout, if output is not specified file is printed to /dev/null")
+	flag.BoolVar(&cfg.Continue, "continue", false, "resume partially downloaded -output file, progress is stored in FILE.curly")
+	flag.IntVar(&cfg.Segments, "segments", 1, "download file over N parallel range requests")
+	flag.StringVar(&cfg.ChunkedPrefix, "output-chunked", "", "FILEPREFIX, content is splitted to -chunk-size files FILEPREFIX.0 FILEPREFIX.1, chunks are joined by: curly join FILEPREFIX")
+	flag.Func("chunk-size", "size of -output-chunked files, e.g. 500KiB, 100MB, 4GiB or preset "+strings.Join(sizePresetNames(), ",")+" (default floppy, 1.44 MB)", func(sizeFlag string) error {
+		size, err := parseSize(sizeFlag)
+		if err != nil {
+			return err
 		}
+		cfg.ChunkSize = size
+		chunkSizeSet = true
 		return nil
 	})
-	flag.StringVar(&cfg.ChunkedPrefix, "output-chunked", "", "FILEPREFIX, content is splitted to 3.5 Mb files FILEPREFIX.0 FILEPREFIX.1")
+	flag.StringVar(&cfg.ChunkCompress, "chunk-compress", "", "compress every -output-chunked file separately: "+strings.Join(supportedCompressions(), "|")+", chunks are named FILEPREFIX.N.gz etc.")
+	flag.BoolVar(&cfg.CompressedChunkSize, "chunk-size-compressed", false, "-chunk-size limits size of compressed chunks instead of raw content")
+	flag.IntVar(&cfg.Chunks, "chunks", 0, "split -output-chunked content into N chunks of even size instead of -chunk-size")
+	flag.IntVar(&cfg.Parity, "parity", 0, "write K Reed-Solomon parity chunks FILEPREFIX.parity.N, curly join rebuilds up to K missing or damaged chunks")
 	flag.BoolVar(&cfg.MD5, "md5", false, "prints md5 sum of file into stderr")
-	flag.BoolVar(&cfg.Upload, "upload", false, "upload file true/false")
+	flag.Func("hash", "comma separated list of hashes printed into stderr: "+strings.Join(supportedHashes(), ","), func(hashFlag string) error {
+		names, err := parseHashes(hashFlag)
+		if err != nil {
+			return err
+		}
+		cfg.Hashes = names
+		return nil
+	})
+	flag.StringVar(&cfg.HashFormat, "hash-format", hashFormatSum, "format of printed hashes: sum (sha256sum compatible), tag (sha256sum --tag compatible) or json")
+	flag.Func("checksum", "expected checksum <hash>:<hex>, e.g. sha256:2c26b4..., download fails if content does not match, -upload is not gated as it streams the content", func(checksumFlag string) error {
+		c, err := parseChecksum(checksumFlag)
+		if err != nil {
+			return err
+		}
+		cfg.Checksum = c
+		return nil
+	})
+	flag.StringVar(&cfg.ChecksumFile, "checksum-file", "", "file with expected checksums in sha256sum format, e.g. SHA256SUMS, untagged 128 characters long checksum requires -hash=sha512 or -hash=blake2b")
+	flag.Var(uploadFlag{&cfg}, "upload", "upload file to -uploadurl, or to S3 by -upload=s3://bucket/key (= is required), key ending with / is prefix of file name")
 	flag.Func("uploadurl", "upload url", func(uploadURL string) error {
 		u, err := url.Parse(uploadURL)
 		if err != nil {
@@ -73,24 +199,263 @@ func ParseConfig(log *zap.SugaredLogger) (*Config, error) {
 		cfg.UploadURL = u
 		return nil
 	})
+	flag.StringVar(&cfg.UploadProtocol, "upload-protocol", uploadMultipart, "protocol of -upload: multipart or tus, tus upload is resumed after dropped connection")
 	flag.BoolVar(&cfg.Verbose, "verbose", false, "verbose output")
+	flag.BoolVar(&cfg.Compressed, "compressed", false, "request compressed content by Accept-Encoding: "+acceptEncoding+" and decode it")
+	flag.BoolVar(&cfg.Raw, "raw", false, "do not decode -compressed content, encoded bytes are saved")
+	flag.StringVar(&cfg.HARFile, "har", "", "FILE where all requests and responses are saved in HTTP Archive 1.2 format")
+	flag.IntVar(&cfg.HARBodySize, "har-body-size", 0, "first N bytes of request and response bodies saved into -har file")
+	flag.BoolVar(&cfg.HARCredentials, "har-credentials", false, "keep Authorization, Cookie, Set-Cookie and other credential headers and cookie values in -har file, they are "+roundtripper.Redacted+" by default")
+	flag.StringVar(&cfg.RecordFile, "record", "", "FILE where all requests and responses including bodies are recorded for -replay, bodies larger than 64 MiB are not recorded and their replay fails")
+	flag.BoolVar(&cfg.RecordCredentials, "record-credentials", false, "keep Authorization, Cookie, Set-Cookie and other credential headers in -record file, they are "+roundtripper.Redacted+" by default")
+	flag.StringVar(&cfg.ReplayFile, "replay", "", "FILE with responses recorded by -record which are replayed instead of accessing network")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", "", "DIR where responses are cached and revalidated by ETag and Last-Modified")
+	flag.Func("cache-max-size", "maximum size of -cache-dir, least recently used responses are evicted, e.g. 500M, 2G", func(sizeFlag string) error {
+		size, err := parseSize(sizeFlag)
+		if err != nil {
+			return err
+		}
+		cfg.CacheMaxSize = size
+		return nil
+	})
+	flag.BoolVar(&cfg.CacheBypass, "cache-bypass", false, "download from network even if response is cached, the response is cached")
+	flag.BoolVar(&cfg.CachePurge, "cache-purge", false, "remove all cached responses before download")
+	flag.Func("proxy", "proxy URL http://[USER:PASSWORD@]HOST:PORT, https:// or socks5://, default is proxy from HTTP_PROXY, HTTPS_PROXY env", func(proxyFlag string) error {
+		u, err := parseProxy(proxyFlag)
+		if err != nil {
+			return err
+		}
+		cfg.Proxy = u
+		return nil
+	})
+	proxyUser := flag.String("proxy-user", "", "USER:PASSWORD of -proxy authentication")
+	flag.Func("noproxy", "comma separated hosts, domains, IP addresses or CIDR ranges which are not proxied, * disables proxy", func(noProxyFlag string) error {
+		cfg.NoProxy = parseNoProxy(noProxyFlag)
+		return nil
+	})
+	flag.StringVar(&cfg.InputFile, "input-file", "", "file with URLs to download, one per line: URL [OUTPUT|-] [CHECKSUM]")
+	flag.IntVar(&cfg.Parallel, "parallel", 1, "download N URLs in parallel")
+	flag.Func("limit-rate", "maximum download rate in bytes per second, e.g. 500K, 2M", func(rateFlag string) error {
+		rate, err := parseSize(rateFlag)
+		if err != nil {
+			return err
+		}
+		cfg.LimitRate = rate
+		return nil
+	})
+	flag.Func("upload-limit-rate", "maximum upload rate in bytes per second, e.g. 500K, 2M", func(rateFlag string) error {
+		rate, err := parseSize(rateFlag)
+		if err != nil {
+			return err
+		}
+		cfg.UploadLimitRate = rate
+		return nil
+	})
+	flag.StringVar(&cfg.Progress, "progress", progressBar, "progress on stderr: bar (rendered only when stderr is a terminal), json (NDJSON events) or none")
+	flag.IntVar(&cfg.Retry, "retry", 0, "retry idempotent requests N times on connection error, 429 or 5xx status, S3 and tus uploads retry on their own")
+	flag.DurationVar(&cfg.RetryMaxTime, "retry-max-time", 0, "maximum time spent by -retry, e.g. 30s, 0 means no limit")
+	flag.DurationVar(&cfg.MaxTime, "max-time", 0, "maximum time of whole download, e.g. 10m, 0 means no limit, tus and S3 uploads are not limited")
+	flag.DurationVar(&cfg.ConnectTimeout, "connect-timeout", 10*time.Second, "maximum time of connection setup incl. TLS handshake, 0 means no limit")
+	flag.BoolVar(&cfg.Encrypt, "encrypt", false, "encrypt -output-chunked chunks and -upload by -key-file or passphrase, encrypted files are decrypted by: curly decrypt or curly join")
+	var sf secretFlags
+	sf.register(flag.CommandLine)
+	var rf requestFlags
+	rf.register(flag.CommandLine)
+	var cf cookieFlags
+	cf.register(flag.CommandLine)
+	var tf tlsFlags
+	tf.register(flag.CommandLine)
+	var s3f s3Flags
+	s3f.register(flag.CommandLine)
 
 	flag.Parse()
+	// boolean -upload does not take the next argument as its value
+	if cfg.Upload && cfg.UploadURL == nil && strings.HasPrefix(flag.Arg(0), s3Scheme+"://") {
+		return nil, fmt.Errorf("S3 upload must be specified as -upload=%s", flag.Arg(0))
+	}
 
-	if len(flag.Args()) == 0 {
+	items, err := batchItems(cfg.InputFile, flag.Args())
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
 		return nil, fmt.Errorf("no file to download")
 	}
-	var err error
-	cfg.DownloadURL, err = url.Parse(flag.Args()[0])
-	if err != nil {
-		return nil, fmt.Errorf("unable parse arg flag: %w", err)
+	if len(items) == 1 && cfg.InputFile == "" {
+		cfg.DownloadURL = items[0].URL
+	} else {
+		cfg.Batch = items
+	}
+
+	if err := rf.apply(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cf.apply(&cfg); err != nil {
+		return nil, err
+	}
+	if (cfg.Continue || cfg.Segments > 1) && (cfg.Method != http.MethodGet || cfg.Body != nil) {
+		return nil, fmt.Errorf("-X, -d, -data-binary, -F and -json can not be combined with -continue and -segments")
+	}
+	if cfg.Raw && !cfg.Compressed {
+		return nil, fmt.Errorf("-raw requires -compressed")
+	}
+	if cfg.Compressed {
+		// ranges of encoded content can not be decoded separately
+		if cfg.Continue || cfg.Segments > 1 {
+			return nil, fmt.Errorf("-compressed can not be combined with -continue and -segments")
+		}
+		if cfg.Header == nil {
+			cfg.Header = make(http.Header)
+		}
+		if _, ok := cfg.Header["Accept-Encoding"]; !ok {
+			cfg.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+	}
+
+	if cfg.Parallel < 1 {
+		return nil, fmt.Errorf("-parallel must be positive, got %d", cfg.Parallel)
+	}
+
+	if *proxyUser != "" {
+		if cfg.Proxy == nil {
+			return nil, fmt.Errorf("-proxy-user requires -proxy")
+		}
+		if err := setProxyUser(cfg.Proxy, *proxyUser); err != nil {
+			return nil, err
+		}
+	}
 
+	if cfg.TLS, err = tf.config(); err != nil {
+		return nil, err
 	}
 
 	if cfg.Upload && cfg.UploadURL == nil {
 		return nil, fmt.Errorf("no upload url specified")
 	}
 
+	if cfg.UploadProtocol != uploadMultipart && cfg.UploadProtocol != uploadTus {
+		return nil, fmt.Errorf("unknown -upload-protocol %q, use %s or %s", cfg.UploadProtocol, uploadMultipart, uploadTus)
+	}
+
+	if cfg.UploadProtocol == uploadTus && !cfg.Upload {
+		return nil, fmt.Errorf("-upload-protocol requires -upload")
+	}
+
+	if err := s3f.apply(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Continue && cfg.Batch == nil && (cfg.Output == "" || cfg.Output == "-") {
+		return nil, fmt.Errorf("-continue requires -output file")
+	}
+
+	if err := validateChunks(&cfg, chunkSizeSet); err != nil {
+		return nil, err
+	}
+
+	if cfg.Parity < 0 {
+		return nil, fmt.Errorf("-parity must be positive, got %d", cfg.Parity)
+	}
+
+	if cfg.Parity > 0 && cfg.ChunkedPrefix == "" {
+		return nil, fmt.Errorf("-parity requires -output-chunked")
+	}
+
+	if _, ok := compressExt[cfg.ChunkCompress]; cfg.ChunkCompress != "" && !ok {
+		return nil, fmt.Errorf("unsupported -chunk-compress %q", cfg.ChunkCompress)
+	}
+
+	if cfg.CompressedChunkSize {
+		switch {
+		case cfg.ChunkCompress == "":
+			return nil, fmt.Errorf("-chunk-size-compressed requires -chunk-compress")
+		case cfg.ChunkSize < minCompressedChunkSize:
+			return nil, fmt.Errorf("compressed -chunk-size must be at least %d bytes", minCompressedChunkSize)
+		}
+	}
+
+	if cfg.Encrypt {
+		if cfg.Secret, err = sf.secret(); err != nil {
+			return nil, err
+		}
+		if cfg.Secret == nil {
+			return nil, fmt.Errorf("-encrypt requires -key-file, -passphrase-file or %s env", passphraseEnv)
+		}
+		if cfg.CompressedChunkSize && cfg.ChunkSize-encrypt.Overhead(cfg.ChunkSize) < minCompressedChunkSize {
+			return nil, fmt.Errorf("compressed -chunk-size %d is too small for -encrypt", cfg.ChunkSize)
+		}
+	} else if sf.set() {
+		return nil, fmt.Errorf("-key-file and -passphrase-file require -encrypt")
+	}
+
+	switch cfg.HashFormat {
+	case hashFormatSum, hashFormatTag, hashFormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported -hash-format %q", cfg.HashFormat)
+	}
+
+	if cfg.ChecksumFile != "" && cfg.Checksum != nil {
+		return nil, fmt.Errorf("-checksum can not be combined with -checksum-file")
+	}
+	if cfg.Checksum != nil && cfg.Batch != nil {
+		return nil, fmt.Errorf("-checksum can not be combined with more URLs, use CHECKSUM of -input-file lines or -checksum-file")
+	}
+
+	// checksums of batch downloads are looked up for every URL
+	if cfg.ChecksumFile != "" && cfg.Batch == nil {
+		var names []string
+		if cfg.Output != "" && cfg.Output != "-" {
+			names = append(names, filepath.Base(cfg.Output))
+		}
+		names = append(names, path.Base(cfg.DownloadURL.Path))
+		cfg.Checksum, err = lookupChecksumFile(cfg.ChecksumFile, cfg.Hashes, names...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Progress {
+	case progressBar, progressJSON, progressNone:
+	default:
+		return nil, fmt.Errorf("unsupported -progress %q", cfg.Progress)
+	}
+
+	if cfg.HARBodySize < 0 {
+		return nil, fmt.Errorf("-har-body-size must be positive, got %d", cfg.HARBodySize)
+	}
+	if cfg.HARCredentials && cfg.HARFile == "" {
+		return nil, fmt.Errorf("-har-credentials requires -har")
+	}
+	if cfg.RecordCredentials && cfg.RecordFile == "" {
+		return nil, fmt.Errorf("-record-credentials requires -record")
+	}
+
+	if cfg.CacheDir == "" && (cfg.CacheMaxSize > 0 || cfg.CacheBypass || cfg.CachePurge) {
+		return nil, fmt.Errorf("-cache-max-size, -cache-bypass and -cache-purge require -cache-dir")
+	}
+
+	if cfg.RecordFile != "" && cfg.ReplayFile != "" {
+		return nil, fmt.Errorf("-record can not be combined with -replay")
+	}
+	if cfg.ReplayFile != "" {
+		cfg.Replayer, err = roundtripper.NewReplayer(cfg.ReplayFile, replayHeaders...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Retry < 0 {
+		return nil, fmt.Errorf("-retry must be positive, got %d", cfg.Retry)
+	}
+
+	if cfg.Segments < 1 {
+		return nil, fmt.Errorf("-segments must be positive, got %d", cfg.Segments)
+	}
+
+	if cfg.Continue && cfg.Segments > 1 {
+		return nil, fmt.Errorf("-continue can not be combined with -segments")
+	}
+
 	return &cfg, nil
 }
 
@@ -99,79 +464,536 @@ func run() error {
 	log := logger.Sugar()
 	defer logger.Sync() // flushes buffer, if any
 
+	if len(os.Args) > 1 && os.Args[1] == "join" {
+		return runJoin(log, os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		return runDecrypt(os.Args[2:])
+	}
+
 	cfg, err := ParseConfig(log)
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
 
-	t := http.DefaultTransport
-	if cfg.Verbose {
-		t = roundtripper.NewDebug(t, logger.Sugar())
+	c := newClient(cfg, log)
+	if cfg.Batch != nil {
+		err = runBatch(log, c, cfg)
+	} else {
+		_, err = download(log, c, cfg)
+	}
+	// cookies are saved even if download failed, e.g. session of failed login
+	if cfg.CookieJar != "" {
+		err = multierr.Append(err, cfg.Jar.Save(cfg.CookieJar))
 	}
+	if cfg.HAR != nil {
+		err = multierr.Append(err, cfg.HAR.Save(cfg.HARFile))
+	}
+	if cfg.Recorder != nil {
+		err = multierr.Append(err, cfg.Recorder.Save())
+	}
+	return err
+}
 
-	c := &http.Client{
-		Transport: t,
-		Timeout:   10 * time.Second,
+// newTransport returns http.DefaultTransport, or its clone when proxy or TLS
+// flags are specified.
+func newTransport(cfg *Config) http.RoundTripper {
+	if cfg.Proxy == nil && cfg.NoProxy == nil && cfg.TLS == nil && cfg.ConnectTimeout == 0 {
+		return http.DefaultTransport
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.ConnectTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   cfg.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		t.TLSHandshakeTimeout = cfg.ConnectTimeout
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.DownloadURL.String(), nil)
+	if cfg.Proxy != nil || cfg.NoProxy != nil {
+		t.Proxy = proxyFunc(cfg)
+	}
+	if cfg.TLS != nil {
+		t.TLSClientConfig = cfg.TLS
+	}
+	return t
+}
+
+// replayHeaders are matched by -replay besides method and URL, so -segments
+// and -continue get recorded ranges.
+var replayHeaders = []string{"Range"}
+
+// newCache returns cache of responses of t, t is returned when cache can not
+// be used, so download does not fail.
+func newCache(cfg *Config, t http.RoundTripper, log *zap.SugaredLogger) http.RoundTripper {
+	cache, err := roundtripper.NewCache(t, cfg.CacheDir, roundtripper.CacheOptions{
+		MaxSize: cfg.CacheMaxSize,
+		Bypass:  cfg.CacheBypass,
+		Logger:  log,
+	})
 	if err != nil {
-		return err
+		log.Warnf("cache is disabled: %s", err)
+		return t
 	}
+	if cfg.CachePurge {
+		if err := cache.Purge(); err != nil {
+			log.Warnf("cache is disabled: %s", err)
+			return t
+		}
+	}
+	return cache
+}
 
-	resp, err := c.Do(req)
+// newClient returns client with transport configured by flags, it sets cfg.HAR
+// and cfg.Recorder when -har and -record are specified.
+func newClient(cfg *Config, log *zap.SugaredLogger) *http.Client {
+	var t http.RoundTripper
+	if cfg.Replayer != nil {
+		t = cfg.Replayer
+	} else {
+		t = newTransport(cfg)
+	}
+	if cfg.RecordFile != "" {
+		cfg.Recorder = roundtripper.NewRecorder(t, cfg.RecordFile)
+		cfg.Recorder.KeepCredentials = cfg.RecordCredentials
+		t = cfg.Recorder
+	}
+	if cfg.HARFile != "" {
+		cfg.HAR = roundtripper.NewHAR(t, cfg.HARBodySize)
+		cfg.HAR.KeepCredentials = cfg.HARCredentials
+		t = cfg.HAR
+	}
+	if cfg.LimitRate > 0 || cfg.UploadLimitRate > 0 {
+		var download, upload *ratelimit.Limiter
+		if cfg.LimitRate > 0 {
+			download = ratelimit.NewLimiter(cfg.LimitRate)
+		}
+		if cfg.UploadLimitRate > 0 {
+			upload = ratelimit.NewLimiter(cfg.UploadLimitRate)
+		}
+		t = roundtripper.NewRateLimit(t, download, upload)
+	}
+	if cfg.CacheDir != "" {
+		t = newCache(cfg, t, log)
+	}
+	if cfg.Verbose {
+		t = roundtripper.NewDebug(t, log)
+	}
+	if cfg.Retry > 0 {
+		t = roundtripper.NewRetry(t, roundtripper.RetryPolicy{
+			MaxRetries: cfg.Retry,
+			MaxTime:    cfg.RetryMaxTime,
+			Logger:     log,
+		})
+	}
+
+	// transfers are limited by -max-time only, so -limit-rate, -retry and
+	// uploads are not cut off
+	c := &http.Client{Transport: t}
+	// nil *cookiejar.Jar must not be stored into http.CookieJar interface
+	if cfg.Jar != nil {
+		c.Jar = cfg.Jar
+	}
+	return c
+}
+
+// download runs pipeline of cfg.DownloadURL, content is written to output,
+// chunks, hashes and upload. It returns number of downloaded bytes.
+func download(log *zap.SugaredLogger, c *http.Client, cfg *Config) (int64, error) {
+	ctx := context.Background()
+	if cfg.MaxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxTime)
+		defer cancel()
+	}
+	body, size, err := openDownload(ctx, log, c, cfg)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer resp.Body.Close()
+	defer func() { body.Close() }()
+	defer closeOutput(cfg)
 
-	r := io.Reader(resp.Body)
+	if cfg.Chunks > 0 {
+		if size < 0 {
+			log.Infof("content length of %s is unknown, spooling to temporary file", cfg.DownloadURL)
+			if body, size, err = spool(body); err != nil {
+				return 0, err
+			}
+		}
+		cfg.ChunkSize = chunkSizeFor(size, cfg.Chunks)
+	}
+
+	counter := &countingWriter{w: io.Discard}
+	r := io.TeeReader(body, counter)
+	p := newProgress(os.Stderr, cfg.Progress, size)
+	if p != nil {
+		p.url = cfg.DownloadURL.String()
+		r = io.TeeReader(r, p.Downloaded())
+	}
 
+	var mc *manifestChunker
 	if len(cfg.ChunkedPrefix) > 0 {
-		chunker, err := NewFileChunker(cfg.ChunkedPrefix)
+		var chunked io.Writer
+		chunked, mc, err = openChunked(cfg, size)
 		if err != nil {
-			return err
+			return 0, err
+		}
+		defer mc.Close()
+		r = io.TeeReader(r, chunked)
+		if p != nil {
+			p.Chunk(mc.Index)
 		}
-		defer chunker.Close()
-		chunked := NewChunked(chunker, floppySize)
-		r = io.TeeReader(resp.Body, chunked)
 	}
 
-	h := md5.New()
+	hashes := cfg.Hashes
 	if cfg.MD5 {
-		r = io.TeeReader(r, h)
+		hashes = appendHash(hashes, "md5")
+	}
+	if cfg.Checksum != nil {
+		hashes = appendHash(hashes, cfg.Checksum.Hash)
+	}
+	digests := newDigests(hashes)
+	if len(hashes) > 0 {
+		r = io.TeeReader(r, digests)
 	}
 
-	if cfg.Upload {
+	var req *http.Request
+	var up uploader
+	switch {
+	case cfg.Upload && cfg.UploadURL.Scheme == s3Scheme:
+		if up, err = newS3Upload(c, cfg, r, p); err != nil {
+			return 0, err
+		}
+	case cfg.Upload && cfg.UploadProtocol == uploadTus:
+		if up, err = newTusUpload(c, cfg, r, p); err != nil {
+			return 0, err
+		}
+	case cfg.Upload:
 		fname := path.Base(cfg.DownloadURL.Path)
-		req, err := request.UploadGZIPZeroMemory(cfg.UploadURL.String(), fname, r)
+		if cfg.Secret != nil {
+			req, err = request.UploadEncryptedGZIPZeroMemory(cfg.UploadURL.String(), fname, r, cfg.Secret)
+		} else {
+			req, err = request.UploadGZIPZeroMemory(cfg.UploadURL.String(), fname, r)
+		}
 		if err != nil {
-			return fmt.Errorf("unable to create UploadGZIPZeroMemory request: %w", err)
+			return 0, fmt.Errorf("unable to create UploadGZIPZeroMemory request: %w", err)
 
 		}
+		if p != nil {
+			req.Body = readCloser{Reader: p.Uploaded(req.Body), Closer: req.Body}
+		}
+		req = req.WithContext(ctx)
+	}
+
+	p.Start()
+	defer p.Stop()
 
+	if req != nil {
 		_, err = c.Do(req)
 		if err != nil {
-			return fmt.Errorf("upload Do failed: %w", err)
+			return 0, fmt.Errorf("upload Do failed: %w", err)
 
 		}
 	}
+	if up != nil {
+		// upload has its own context, so it is resumed when it takes longer
+		// than -max-time of the download
+		location, err := up.Upload(context.Background())
+		if err != nil {
+			return 0, fmt.Errorf("upload failed: %w", err)
+		}
+		log.Debugf("upload has finished: %s", location)
+	}
 
 	if _, err := io.Copy(cfg.Std, r); err != nil {
-		return fmt.Errorf("io.Copy failed: %w", err)
+		return 0, fmt.Errorf("io.Copy failed: %w", err)
 	}
+	p.Stop()
 	log.Debugf("download has finished successfuly: %s", cfg.DownloadURL)
 
+	if len(cfg.Hashes) > 0 {
+		if err := digests.Select(cfg.Hashes).Print(os.Stderr, cfg.HashFormat, hashFilename(cfg)); err != nil {
+			return 0, fmt.Errorf("unable to print hashes: %w", err)
+		}
+	}
 	if cfg.MD5 {
-		msg := fmt.Sprintf("MD5 sum: %x", h.Sum(nil))
-		log.Errorw(msg)
+		sum, _ := digests.Sum("md5")
+		log.Errorw(fmt.Sprintf("MD5 sum: %s", sum))
+	}
+
+	// upload streams the content, so it is finished before the checksum is
+	// verified and it is not gated by -checksum
+	if cfg.Checksum != nil {
+		if err := cfg.Checksum.verify(digests); err != nil {
+			if cfg.Upload {
+				err = fmt.Errorf("%w, mismatched content has been already uploaded", err)
+			}
+			return 0, multierr.Append(err, discardOutput(cfg))
+		}
+	}
+
+	if cfg.Continue {
+		if err := os.Remove(stateFilename(cfg.Output)); err != nil {
+			return 0, fmt.Errorf("unable to remove resume state: %w", err)
+		}
+	}
+
+	if mc != nil {
+		if err := mc.Close(); err != nil {
+			return 0, fmt.Errorf("unable to close chunks: %w", err)
+		}
+		if cfg.Parity > 0 {
+			if err := writeParity(cfg.ChunkedPrefix, mc.Manifest(), cfg.Parity); err != nil {
+				return 0, err
+			}
+		}
+		if cfg.Secret != nil {
+			err = mc.Manifest().saveEncrypted(encryptedManifestFilename(cfg.ChunkedPrefix), cfg.Secret)
+		} else {
+			err = mc.Manifest().save(manifestFilename(cfg.ChunkedPrefix))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return counter.n, nil
+}
+
+// openDownload starts download of cfg.DownloadURL and sets cfg.Std output
+// accordingly. Returned body streams whole content of the resource in order,
+// size of the content is -1 if it is not known.
+func openDownload(ctx context.Context, log *zap.SugaredLogger, c *http.Client, cfg *Config) (io.ReadCloser, int64, error) {
+	switch {
+	case cfg.Continue:
+		resp, f, offset, err := resumeDownload(ctx, log, c, cfg.DownloadURL, cfg.Header, cfg.Output)
+		if err != nil {
+			return nil, 0, err
+		}
+		size := resp.ContentLength
+		if size >= 0 {
+			size += offset
+		}
+		cfg.Std = &skipWriter{w: f, n: offset}
+		// replay already downloaded bytes so hashes and chunks cover whole file
+		return readCloser{
+			Reader: io.MultiReader(io.NewSectionReader(f, 0, offset), resp.Body),
+			Closer: closerFunc(func() error {
+				return multierr.Combine(resp.Body.Close(), f.Close())
+			}),
+		}, size, nil
+	case cfg.Segments > 1:
+		size, ok, err := probeRanges(ctx, c, cfg.DownloadURL, cfg.Header)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			body, err := openSegmented(ctx, c, cfg, size)
+			return body, size, err
+		}
+		log.Warnf("server does not support range requests, downloading %s in single stream", cfg.DownloadURL)
+	}
+
+	if err := openOutput(cfg); err != nil {
+		return nil, 0, err
+	}
+	req, err := newDownloadRequest(ctx, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeResponse(log, cfg, resp)
+}
+
+// openSegmented downloads segments directly into -output file, if output is
+// stdout or not specified segments are stored in temporary file.
+func openSegmented(ctx context.Context, c *http.Client, cfg *Config, size int64) (io.ReadCloser, error) {
+	var f *os.File
+	var err error
+	cleanup := func() error { return f.Close() }
+	if cfg.Output == "" || cfg.Output == "-" {
+		if err := openOutput(cfg); err != nil {
+			return nil, err
+		}
+		f, err = os.CreateTemp("", "curly-segments-")
+		if err != nil {
+			return nil, fmt.Errorf("unable to create temp file: %w", err)
+		}
+		cleanup = func() error { return multierr.Combine(f.Close(), os.Remove(f.Name())) }
+	} else {
+		f, err = os.Create(cfg.Output)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create os file: %w", err)
+		}
+		// segments are written to the file directly
+		cfg.Std = stdnull
+	}
+
+	seg, err := newSegmented(ctx, c, cfg.DownloadURL, cfg.Header, f, size, cfg.Segments)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return readCloser{
+		Reader: seg,
+		Closer: closerFunc(func() error {
+			return multierr.Combine(seg.Close(), cleanup())
+		}),
+	}, nil
+}
+
+// openChunked returns writer which splits content into -output-chunked files
+// and manifestChunker which records written chunks. Chunks are compressed
+// first and then encrypted. size of content is used by -chunks.
+func openChunked(cfg *Config, size int64) (io.Writer, *manifestChunker, error) {
+	// encryption adds header and tags to compressed content
+	compressedSize := cfg.ChunkSize
+	if cfg.Secret != nil {
+		compressedSize -= encrypt.Overhead(compressedSize)
+	}
+	// invalid size is rejected before the first chunk file is created
+	switch {
+	case cfg.CompressedChunkSize && compressedSize < minCompressedChunkSize:
+		return nil, nil, fmt.Errorf("invalid chunk size %d: compressed chunk size must be at least %d", compressedSize, minCompressedChunkSize)
+	case !cfg.CompressedChunkSize && cfg.Chunks <= 0 && cfg.ChunkSize <= 0:
+		return nil, nil, fmt.Errorf("invalid chunk size %d: chunk size must be positive", cfg.ChunkSize)
+	}
+
+	fc, err := newFileChunker(cfg.ChunkedPrefix, chunkExt(cfg.ChunkCompress, cfg.Secret != nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	chunker := Chunker(fc)
+	if cfg.Secret != nil {
+		if chunker, err = newEncryptChunker(chunker, cfg.Secret); err != nil {
+			fc.Close()
+			return nil, nil, err
+		}
+	}
+	var compressed *compressChunker
+	if cfg.ChunkCompress != "" {
+		if compressed, err = newCompressChunker(chunker, cfg.ChunkCompress); err != nil {
+			chunker.Close()
+			return nil, nil, err
+		}
+		chunker = compressed
+	}
+
+	mc := newManifestChunker(chunker, cfg.DownloadURL.String(), cfg.ChunkSize)
+	mc.Manifest().Compression = cfg.ChunkCompress
+	mc.Manifest().CompressedChunkSize = cfg.CompressedChunkSize
+	mc.Manifest().Encrypted = cfg.Secret != nil
+
+	var chunked io.Writer
+	if cfg.CompressedChunkSize {
+		chunked, err = newCompressedChunked(mc, compressed, compressedSize)
+	} else if cfg.Chunks > 0 {
+		chunked, err = NewEvenChunked(mc, size, cfg.Chunks)
+	} else {
+		chunked, err = NewChunked(mc, cfg.ChunkSize)
+	}
+	if err != nil {
+		mc.Close()
+		return nil, nil, err
+	}
+	return chunked, mc, nil
+}
+
+// spool copies body into temporary file so its size is known. Returned body
+// reads the temporary file and removes it on Close, original body is closed.
+func spool(body io.ReadCloser) (io.ReadCloser, int64, error) {
+	defer body.Close()
+	f, err := os.CreateTemp("", "curly-spool-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to create temp file: %w", err)
+	}
+	cleanup := func() error { return multierr.Combine(f.Close(), os.Remove(f.Name())) }
+
+	size, err := io.Copy(f, body)
+	if err != nil {
+		cleanup()
+		return nil, 0, fmt.Errorf("unable to spool content: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, fmt.Errorf("unable to seek spooled content: %w", err)
+	}
+	return readCloser{Reader: f, Closer: closerFunc(cleanup)}, size, nil
+}
+
+// hashFilename returns file name printed next to hashes, it is -output file or
+// name of downloaded file if output is not a file.
+func hashFilename(cfg *Config) string {
+	if cfg.Output != "" && cfg.Output != "-" {
+		return cfg.Output
+	}
+	if name := path.Base(cfg.DownloadURL.Path); name != "/" && name != "." {
+		return name
+	}
+	return "-"
+}
+
+// discardOutput renames -output file which does not match expected checksum to
+// FILE.partial so it is not mistaken for a valid download.
+func discardOutput(cfg *Config) error {
+	if cfg.Continue {
+		if err := os.Remove(stateFilename(cfg.Output)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to remove resume state: %w", err)
+		}
+	}
+	if cfg.Output == "" || cfg.Output == "-" {
+		return nil
+	}
+	if err := os.Rename(cfg.Output, cfg.Output+partialSuffix); err != nil {
+		return fmt.Errorf("unable to rename mismatched output: %w", err)
+	}
+	return nil
+}
+
+// openOutput sets cfg.Std according to -output flag, if value is '-' output is
+// stdout, if output is not specified output is discarded.
+func openOutput(cfg *Config) error {
+	switch {
+	case cfg.Output == "-":
+		cfg.Std = stdout
+	case len(cfg.Output) > 0:
+		f, err := os.Create(cfg.Output)
+		if err != nil {
+			return fmt.Errorf("unable to create os file: %w", err)
+		}
+		cfg.Std = f
+	default:
+		cfg.Std = stdnull
 	}
 	return nil
 }
 
+// closeOutput closes -output file opened by openOutput.
+func closeOutput(cfg *Config) {
+	if f, ok := cfg.Std.(*os.File); ok && f != stdout {
+		f.Close()
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
 func main() {
 	if err := run(); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			log.Print(err)
+			os.Exit(exitChecksumMismatch)
+		}
 		log.Fatal(err)
 	}
 	os.Exit(0)
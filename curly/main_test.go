@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDownload_MaxTime(t *testing.T) {
+	// content is sent in 3.6s, transfers were cut off after 3s before
+	// -max-time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 9; i++ {
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(400 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/slow")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		maxTime time.Duration
+		wantErr string
+	}{
+		{name: "no limit"},
+		{name: "limit", maxTime: 500 * time.Millisecond, wantErr: "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				DownloadURL:    u,
+				Method:         http.MethodGet,
+				Output:         filepath.Join(t.TempDir(), "out"),
+				Progress:       progressNone,
+				MaxTime:        tt.maxTime,
+				ConnectTimeout: time.Second,
+			}
+			n, err := download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(9), n)
+			got, err := os.ReadFile(cfg.Output)
+			require.NoError(t, err)
+			assert.Equal(t, strings.Repeat("x", 9), string(got))
+		})
+	}
+}
+
+func TestDownload_MD5(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("123456789"))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/file")
+	require.NoError(t, err)
+
+	core, logs := observer.New(zap.InfoLevel)
+	cfg := &Config{
+		DownloadURL: u,
+		Method:      http.MethodGet,
+		Std:         stdnull,
+		MD5:         true,
+		HashFormat:  hashFormatSum,
+		Progress:    progressNone,
+	}
+	_, err = download(zap.New(core).Sugar(), ts.Client(), cfg)
+	require.NoError(t, err)
+	// -md5 keeps its original log line, -hash=md5 prints sha256sum compatible line
+	entries := logs.FilterMessage("MD5 sum: 25f9e794323b453885f5181f1b624d0b").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zap.ErrorLevel, entries[0].Level)
+}
+
+func TestDownload_ChecksumUpload(t *testing.T) {
+	var uploaded bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			io.Copy(io.Discard, r.Body)
+			uploaded = true
+			return
+		}
+		w.Write([]byte("123456789"))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL + "/file")
+	require.NoError(t, err)
+	uploadURL, err := url.Parse(ts.URL + "/upload")
+	require.NoError(t, err)
+
+	cfg := &Config{
+		DownloadURL: u,
+		Method:      http.MethodGet,
+		Upload:      true,
+		UploadURL:   uploadURL,
+		Std:         stdnull,
+		Checksum:    &checksum{Hash: "md5", Sum: "00000000000000000000000000000000"},
+		Progress:    progressNone,
+	}
+	_, err = download(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), err)
+	// upload is not gated by -checksum
+	assert.Contains(t, err.Error(), "mismatched content has been already uploaded")
+	assert.True(t, uploaded)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
+)
+
+const manifestHash = "sha256"
+
+// Manifest describes chunks written by -output-chunked. Chunk names are
+// relative to the manifest directory so chunks can be moved to other machine
+// and verified or joined there.
+type Manifest struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	// Compression of chunks, chunk name is the compressed file, chunk size
+	// and hash describe decompressed content.
+	Compression string `json:"compression,omitempty"`
+	// CompressedChunkSize is true when ChunkSize limits compressed chunks.
+	CompressedChunkSize bool `json:"compressed_chunk_size,omitempty"`
+	// Encrypted is true when chunks are encrypted, chunk size and hash
+	// describe decrypted content.
+	Encrypted bool            `json:"encrypted,omitempty"`
+	Hash      string          `json:"hash"`
+	Chunks    []ManifestChunk `json:"chunks"`
+	// Parity describes parity chunks written by -parity.
+	Parity *ManifestParity `json:"parity,omitempty"`
+}
+
+type ManifestChunk struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Hash   string `json:"hash"`
+}
+
+// ManifestParity describes Reed-Solomon parity chunks. Parity is computed of
+// chunk files as they are stored, compressed or encrypted, every file is padded
+// by zeros to ShardSize.
+type ManifestParity struct {
+	ShardSize int64 `json:"shard_size"`
+	// Data are chunk files in order of Manifest.Chunks, their hashes find
+	// damaged chunks.
+	Data   []ManifestFile `json:"data"`
+	Chunks []ManifestFile `json:"chunks"`
+}
+
+// ManifestFile is size and hash of a file as it is stored.
+type ManifestFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+func manifestFilename(prefix string) string {
+	return fmt.Sprintf("%s.manifest.json", prefix)
+}
+
+// encryptedManifestFilename returns name of manifest encrypted by -encrypt.
+func encryptedManifestFilename(prefix string) string {
+	return manifestFilename(prefix) + encryptedExt
+}
+
+func (m *Manifest) save(fname string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(fname, b, 0o644); err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+	return nil
+}
+
+// saveEncrypted writes manifest encrypted by secret s, so chunk hashes do not
+// reveal the content.
+func (m *Manifest) saveEncrypted(fname string, s *encrypt.Secret) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode manifest: %w", err)
+	}
+	var buf bytes.Buffer
+	ew, err := encrypt.NewWriter(&buf, s, manifestAD)
+	if err != nil {
+		return err
+	}
+	if _, err := ew.Write(b); err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(fname, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+	return nil
+}
+
+// loadManifest reads manifest of chunks, nil manifest is returned when
+// manifest file does not exist.
+func loadManifest(fname string) (*Manifest, error) {
+	b, err := os.ReadFile(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	return decodeManifest(fname, b)
+}
+
+// loadEncryptedManifest reads manifest encrypted by secret s, nil manifest is
+// returned when manifest file does not exist.
+func loadEncryptedManifest(fname string, s *encrypt.Secret) (*Manifest, error) {
+	f, err := os.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	defer f.Close()
+	r, err := encrypt.NewReader(f, s, manifestAD)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt manifest %s: %w", fname, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt manifest %s: %w", fname, err)
+	}
+	return decodeManifest(fname, b)
+}
+
+func decodeManifest(fname string, b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unable to decode manifest %s: %w", fname, err)
+	}
+	names := make([]string, 0, len(m.Chunks))
+	for _, c := range m.Chunks {
+		names = append(names, c.Name)
+	}
+	if m.Parity != nil {
+		for _, f := range append(append([]ManifestFile{}, m.Parity.Data...), m.Parity.Chunks...) {
+			names = append(names, f.Name)
+		}
+	}
+	for _, name := range names {
+		if err := checkChunkName(name); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", fname, err)
+		}
+	}
+	return &m, nil
+}
+
+// checkChunkName rejects chunk names which are not plain file names, chunks
+// are read from and rebuilt into the manifest directory only.
+func checkChunkName(name string) error {
+	if name == "" || name == "." || name != filepath.Base(name) || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("chunk name %q is outside of manifest directory", name)
+	}
+	return nil
+}
+
+func formatHash(h hash.Hash) string {
+	return fmt.Sprintf("%s:%x", manifestHash, h.Sum(nil))
+}
+
+// parseHash returns digest of "sha256:<hex>" value.
+func parseHash(s string) ([]byte, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 || s[:i] != manifestHash {
+		return nil, fmt.Errorf("unsupported hash %q", s)
+	}
+	return hex.DecodeString(s[i+1:])
+}
+
+var _ Chunker = (*manifestChunker)(nil)
+
+// manifestChunker records every chunk written into underlying Chunker together
+// with its hash so the manifest is built while streaming.
+type manifestChunker struct {
+	Chunker
+	manifest Manifest
+	chunk    ManifestChunk
+	chunkH   hash.Hash
+	fileH    hash.Hash
+	closed   bool
+	// idx is index of current chunk, it is read concurrently by progress
+	idx int32
+}
+
+func newManifestChunker(c Chunker, url string, chunkSize int64) *manifestChunker {
+	return &manifestChunker{
+		Chunker: c,
+		manifest: Manifest{
+			URL:       url,
+			ChunkSize: chunkSize,
+		},
+		chunk:  ManifestChunk{Name: filepath.Base(c.Name())},
+		chunkH: sha256.New(),
+		fileH:  sha256.New(),
+	}
+}
+
+func (m *manifestChunker) Write(p []byte) (int, error) {
+	n, err := m.Chunker.Write(p)
+	m.chunkH.Write(p[:n])
+	m.fileH.Write(p[:n])
+	m.chunk.Size += int64(n)
+	m.manifest.Size += int64(n)
+	return n, err
+}
+
+func (m *manifestChunker) NewChunk() error {
+	m.finishChunk()
+	if err := m.Chunker.NewChunk(); err != nil {
+		return err
+	}
+	m.chunk = ManifestChunk{
+		Name:   filepath.Base(m.Chunker.Name()),
+		Offset: m.manifest.Size,
+	}
+	atomic.AddInt32(&m.idx, 1)
+	return nil
+}
+
+// Index returns index of chunk which is currently written, it is safe to call
+// it concurrently with writes.
+func (m *manifestChunker) Index() int {
+	return int(atomic.LoadInt32(&m.idx))
+}
+
+func (m *manifestChunker) finishChunk() {
+	m.chunk.Hash = formatHash(m.chunkH)
+	m.manifest.Chunks = append(m.manifest.Chunks, m.chunk)
+	m.chunkH.Reset()
+}
+
+// Close closes underlying Chunker and finishes the last chunk, it is safe to
+// call Close multiple times.
+func (m *manifestChunker) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.finishChunk()
+	m.manifest.Hash = formatHash(m.fileH)
+	return m.Chunker.Close()
+}
+
+// Manifest returns manifest of written chunks, it is complete after Close.
+func (m *manifestChunker) Manifest() *Manifest {
+	return &m.manifest
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestChunker(t *testing.T) {
+	hashOf := func(s string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s)))
+	}
+
+	tChunker := NewTestChunked()
+	mc := newManifestChunker(tChunker, "http://example.com/file", 5)
+	c, err := NewChunked(mc, 5)
+	require.NoError(t, err)
+	for _, p := range []string{"123", "456", "789", "123"} {
+		_, err := c.Write([]byte(p))
+		require.NoError(t, err)
+	}
+	require.NoError(t, mc.Close())
+	require.NoError(t, mc.Close())
+
+	want := &Manifest{
+		URL:       "http://example.com/file",
+		Size:      12,
+		ChunkSize: 5,
+		Hash:      hashOf("123456789123"),
+		Chunks: []ManifestChunk{
+			{Name: "test.0", Offset: 0, Size: 5, Hash: hashOf("12345")},
+			{Name: "test.1", Offset: 5, Size: 5, Hash: hashOf("67891")},
+			{Name: "test.2", Offset: 10, Size: 2, Hash: hashOf("23")},
+		},
+	}
+	assert.Empty(t, cmp.Diff(want, mc.Manifest()))
+}
+
+func TestCheckChunkName(t *testing.T) {
+	for _, name := range []string{"foo.0", "foo.1.gz.enc"} {
+		assert.NoError(t, checkChunkName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "foo..0", "../foo.0", "dir/foo.0", "/etc/passwd", `..\foo.0`} {
+		assert.Error(t, checkChunkName(name), name)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/erasure"
+)
+
+// parityBlockSize is number of bytes of every shard encoded at once.
+const parityBlockSize = 64 << 10
+
+// parityFilename returns name of parity chunk FILEPREFIX.parity.N.
+func parityFilename(prefix string, idx int) string {
+	return fmt.Sprintf("%s.parity.%d", prefix, idx)
+}
+
+// shardFile is a chunk file read or written as a shard padded by zeros.
+type shardFile struct {
+	f    *os.File
+	name string
+	size int64
+	h    hash.Hash
+	// created shards are written, the others are read
+	created bool
+}
+
+func (s *shardFile) file() ManifestFile {
+	return ManifestFile{Name: filepath.Base(s.name), Size: s.size, Hash: formatHash(s.h)}
+}
+
+// read reads block of shard at offset off, bytes behind end of file are zero.
+func (s *shardFile) read(block []byte, off int64) error {
+	n := int64(0)
+	if off < s.size {
+		n = s.size - off
+		if n > int64(len(block)) {
+			n = int64(len(block))
+		}
+		if _, err := io.ReadFull(s.f, block[:n]); err != nil {
+			return fmt.Errorf("unable to read chunk %s: %w", s.name, err)
+		}
+		s.h.Write(block[:n])
+	}
+	for i := n; i < int64(len(block)); i++ {
+		block[i] = 0
+	}
+	return nil
+}
+
+// write writes block of shard at offset off, padding behind size of the
+// shard is not written.
+func (s *shardFile) write(block []byte, off int64) error {
+	if off >= s.size {
+		return nil
+	}
+	if n := s.size - off; n < int64(len(block)) {
+		block = block[:n]
+	}
+	s.h.Write(block)
+	if _, err := s.f.Write(block); err != nil {
+		return fmt.Errorf("unable to write chunk %s: %w", s.name, err)
+	}
+	return nil
+}
+
+func closeShards(shards []*shardFile) error {
+	var err error
+	for _, s := range shards {
+		if s != nil && s.f != nil {
+			err = multierr.Append(err, s.f.Close())
+		}
+	}
+	return err
+}
+
+// encodeShards reads existing shards block by block, computes blocks of created
+// shards by rebuild and writes them.
+func encodeShards(shards []*shardFile, shardSize int64, rebuild func(blocks [][]byte) error) error {
+	blocks := make([][]byte, len(shards))
+	for off := int64(0); off < shardSize; off += parityBlockSize {
+		n := shardSize - off
+		if n > parityBlockSize {
+			n = parityBlockSize
+		}
+		for i := range blocks {
+			if blocks[i] == nil {
+				blocks[i] = make([]byte, parityBlockSize)
+			}
+			blocks[i] = blocks[i][:n]
+		}
+		for i, s := range shards {
+			if !s.created {
+				if err := s.read(blocks[i], off); err != nil {
+					return err
+				}
+			}
+		}
+		if err := rebuild(blocks); err != nil {
+			return err
+		}
+		for i, s := range shards {
+			if s.created {
+				if err := s.write(blocks[i], off); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeParity writes k parity chunks of chunks listed in manifest m and
+// records them in the manifest.
+func writeParity(prefix string, m *Manifest, k int) (err error) {
+	dir := filepath.Dir(prefix)
+	n := len(m.Chunks)
+	if n+k > erasure.MaxShards {
+		return fmt.Errorf("-parity %d with %d chunks exceeds maximum %d chunks, use larger -chunk-size", k, n, erasure.MaxShards)
+	}
+
+	shards := make([]*shardFile, n+k)
+	defer func() { err = multierr.Append(err, closeShards(shards)) }()
+	var shardSize int64
+	for i, c := range m.Chunks {
+		name := filepath.Join(dir, c.Name)
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("unable to open chunk: %w", err)
+		}
+		shards[i] = &shardFile{f: f, name: name, h: sha256.New()}
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("unable to stat chunk: %w", err)
+		}
+		shards[i].size = fi.Size()
+		if fi.Size() > shardSize {
+			shardSize = fi.Size()
+		}
+	}
+	for p := 0; p < k; p++ {
+		name := parityFilename(prefix, p)
+		f, err := os.Create(name)
+		if err != nil {
+			return fmt.Errorf("unable to create parity chunk: %w", err)
+		}
+		shards[n+p] = &shardFile{f: f, name: name, size: shardSize, h: sha256.New(), created: true}
+	}
+
+	err = encodeShards(shards, shardSize, func(blocks [][]byte) error {
+		return erasure.Encode(blocks[:n], blocks[n:])
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Parity = &ManifestParity{ShardSize: shardSize}
+	for i, s := range shards {
+		if i < n {
+			m.Parity.Data = append(m.Parity.Data, s.file())
+		} else {
+			m.Parity.Chunks = append(m.Parity.Chunks, s.file())
+		}
+	}
+	return nil
+}
+
+// checkFile returns true if file exists and matches its size and hash.
+func checkFile(name string, want ManifestFile) (bool, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to open chunk: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return false, fmt.Errorf("unable to read chunk %s: %w", name, err)
+	}
+	return n == want.Size && verifyHash(want.Hash, h) == nil, nil
+}
+
+// repairChunks finds missing and damaged chunks by their size and hash and
+// rebuilds them from parity chunks. Rebuilt chunks replace the damaged ones.
+func repairChunks(log *zap.SugaredLogger, prefix string, m *Manifest) (err error) {
+	dir := filepath.Dir(prefix)
+	files := append(append([]ManifestFile{}, m.Parity.Data...), m.Parity.Chunks...)
+	n := len(m.Parity.Data)
+	if n != len(m.Chunks) {
+		return fmt.Errorf("manifest has %d chunks and %d parity data files", len(m.Chunks), n)
+	}
+
+	present := make([]bool, len(files))
+	var damaged int
+	for i, file := range files {
+		ok, err := checkFile(filepath.Join(dir, file.Name), file)
+		if err != nil {
+			return err
+		}
+		present[i] = ok
+		if !ok {
+			log.Warnf("chunk %s is missing or damaged", file.Name)
+			damaged++
+		}
+	}
+	if damaged == 0 {
+		return nil
+	}
+	r, err := erasure.NewReconstructor(n, present)
+	if err != nil {
+		return fmt.Errorf("unable to rebuild %d damaged chunks from %d parity chunks: %w", damaged, len(m.Parity.Chunks), err)
+	}
+
+	// rebuilt chunks are written to temporary files first, so damaged chunks
+	// are kept when rebuild fails
+	shards := make([]*shardFile, len(files))
+	defer func() {
+		err = multierr.Append(err, closeShards(shards))
+		for _, s := range shards {
+			if s != nil && s.created {
+				os.Remove(s.name)
+			}
+		}
+	}()
+	for i, file := range files {
+		name := filepath.Join(dir, file.Name)
+		shards[i] = &shardFile{name: name, size: file.Size, h: sha256.New()}
+		if present[i] {
+			if shards[i].f, err = os.Open(name); err != nil {
+				return fmt.Errorf("unable to open chunk: %w", err)
+			}
+			continue
+		}
+		shards[i].name = name + ".rebuilt"
+		if shards[i].f, err = os.Create(shards[i].name); err != nil {
+			return fmt.Errorf("unable to create chunk: %w", err)
+		}
+		shards[i].created = true
+	}
+
+	if err := encodeShards(shards, m.Parity.ShardSize, r.Reconstruct); err != nil {
+		return err
+	}
+	for i, s := range shards {
+		if !s.created {
+			continue
+		}
+		if err := verifyHash(files[i].Hash, s.h); err != nil {
+			return fmt.Errorf("rebuilt chunk %s is corrupted: %w", files[i].Name, err)
+		}
+		closeErr := s.f.Close()
+		s.f = nil
+		if closeErr != nil {
+			return fmt.Errorf("unable to close rebuilt chunk: %w", closeErr)
+		}
+		name := filepath.Join(dir, files[i].Name)
+		if err := os.Rename(s.name, name); err != nil {
+			return fmt.Errorf("unable to replace damaged chunk: %w", err)
+		}
+		s.created = false
+		log.Warnf("chunk %s was rebuilt from parity chunks", files[i].Name)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParity(t *testing.T) {
+	content := make([]byte, 10_000)
+	rand.New(rand.NewSource(1)).Read(content)
+	const chunkSize = 1000
+	const parity = 3
+
+	damage := func(prefix string, name string) {
+		b, _ := os.ReadFile(filepath.Join(filepath.Dir(prefix), name))
+		b[len(b)/2] ^= 1
+		os.WriteFile(filepath.Join(filepath.Dir(prefix), name), b, 0o644)
+	}
+	tests := []struct {
+		name    string
+		modify  func(prefix string)
+		wantErr bool
+	}{
+		{
+			name:   "no damage",
+			modify: func(prefix string) {},
+		},
+		{
+			name: "missing chunks",
+			modify: func(prefix string) {
+				os.Remove(filename(prefix, 0))
+				os.Remove(filename(prefix, 5))
+				os.Remove(filename(prefix, 9))
+			},
+		},
+		{
+			name: "damaged and truncated chunks",
+			modify: func(prefix string) {
+				damage(prefix, "foo.3")
+				os.Truncate(filename(prefix, 4), 10)
+			},
+		},
+		{
+			name: "damaged parity and data chunks",
+			modify: func(prefix string) {
+				os.Remove(parityFilename(prefix, 1))
+				damage(prefix, "foo.parity.0")
+				damage(prefix, "foo.7")
+			},
+		},
+		{
+			name: "more damaged chunks than parity",
+			modify: func(prefix string) {
+				os.Remove(filename(prefix, 1))
+				os.Remove(filename(prefix, 2))
+				os.Remove(parityFilename(prefix, 0))
+				damage(prefix, "foo.3")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "foo")
+			writeChunks(t, prefix, content, chunkSize)
+			m, err := loadManifest(manifestFilename(prefix))
+			require.NoError(t, err)
+			require.NoError(t, writeParity(prefix, m, parity))
+			require.NoError(t, m.save(manifestFilename(prefix)))
+			require.Len(t, m.Parity.Chunks, parity)
+
+			tt.modify(prefix)
+
+			output := filepath.Join(t.TempDir(), "joined")
+			err = runJoin(zap.NewNop().Sugar(), []string{"-output", output, prefix})
+			if tt.wantErr {
+				assert.Error(t, err)
+				// damaged chunks are kept when they can not be rebuilt
+				_, err := os.Stat(filename(prefix, 3))
+				assert.NoError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			got, err := os.ReadFile(output)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(content, got))
+
+			// all chunks are restored
+			for _, f := range append(m.Parity.Data, m.Parity.Chunks...) {
+				ok, err := checkFile(filepath.Join(filepath.Dir(prefix), f.Name), f)
+				require.NoError(t, err)
+				assert.True(t, ok, f.Name)
+			}
+		})
+	}
+}
+
+func TestParity_CompressedChunks(t *testing.T) {
+	content := bytes.Repeat([]byte("parity of compressed chunks "), 5000)
+	cfg := Config{
+		ChunkedPrefix: filepath.Join(t.TempDir(), "foo"),
+		ChunkSize:     16 << 10,
+		ChunkCompress: "gzip",
+	}
+	keyFile := writeEncryptedChunks(t, &cfg, content)
+	m, err := loadEncryptedManifest(encryptedManifestFilename(cfg.ChunkedPrefix), cfg.Secret)
+	require.NoError(t, err)
+	require.NoError(t, writeParity(cfg.ChunkedPrefix, m, 1))
+	require.NoError(t, m.saveEncrypted(encryptedManifestFilename(cfg.ChunkedPrefix), cfg.Secret))
+
+	// chunks differ in size, shards are padded
+	require.NoError(t, os.Remove(filename(cfg.ChunkedPrefix, len(m.Chunks)-1)+".gz.enc"))
+
+	output := filepath.Join(t.TempDir(), "joined")
+	require.NoError(t, runJoin(zap.NewNop().Sugar(), []string{"-key-file", keyFile, "-output", output, cfg.ChunkedPrefix}))
+	got, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, got))
+}
+
+func TestWriteParity_TooManyChunks(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "foo")
+	writeChunks(t, prefix, make([]byte, 300), 1)
+	m, err := loadManifest(manifestFilename(prefix))
+	require.NoError(t, err)
+	assert.Error(t, writeParity(prefix, m, 1))
+}
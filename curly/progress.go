@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	progressBar  = "bar"
+	progressJSON = "json"
+	progressNone = "none"
+
+	progressBarInterval  = 200 * time.Millisecond
+	progressJSONInterval = time.Second
+)
+
+// progressEvent is a line of -progress=json output.
+type progressEvent struct {
+	URL        string   `json:"url,omitempty"`
+	Elapsed    float64  `json:"elapsed_seconds"`
+	Downloaded int64    `json:"downloaded"`
+	Total      int64    `json:"total,omitempty"`
+	Percent    *float64 `json:"percent,omitempty"`
+	Rate       float64  `json:"rate"`
+	ETA        *float64 `json:"eta_seconds,omitempty"`
+	Chunk      *int     `json:"chunk,omitempty"`
+	Uploaded   *int64   `json:"uploaded,omitempty"`
+	Done       bool     `json:"done"`
+}
+
+// progress periodically reports transferred bytes into w, it renders progress
+// bar or NDJSON events.
+type progress struct {
+	// downloaded and uploaded are updated concurrently by counters, they are
+	// first to be 64-bit aligned for atomic operations
+	downloaded int64
+	uploaded   int64
+
+	w        io.Writer
+	url      string
+	format   string
+	interval time.Duration
+	total    int64
+	start    time.Time
+	now      func() time.Time
+
+	upload bool
+	chunk  func() int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	width    int
+}
+
+// newProgress returns progress of download with total bytes, total is -1 if it
+// is unknown. Progress bar is rendered only if w is a terminal, nil progress is
+// returned when there is nothing to report.
+func newProgress(w *os.File, format string, total int64) *progress {
+	switch format {
+	case progressNone:
+		return nil
+	case progressBar:
+		if !term.IsTerminal(int(w.Fd())) {
+			return nil
+		}
+	}
+	return newProgressWriter(w, format, total)
+}
+
+func newProgressWriter(w io.Writer, format string, total int64) *progress {
+	interval := progressBarInterval
+	if format == progressJSON {
+		interval = progressJSONInterval
+	}
+	return &progress{
+		w:        w,
+		format:   format,
+		interval: interval,
+		total:    total,
+		start:    time.Now(),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Downloaded returns writer which counts downloaded bytes.
+func (p *progress) Downloaded() io.Writer {
+	return progressCounter{n: &p.downloaded}
+}
+
+// Uploaded returns reader which counts uploaded bytes read from r.
+func (p *progress) Uploaded(r io.Reader) io.Reader {
+	p.upload = true
+	return io.TeeReader(r, progressCounter{n: &p.uploaded})
+}
+
+// Chunk sets function which returns index of current -output-chunked chunk.
+func (p *progress) Chunk(index func() int) {
+	p.chunk = index
+}
+
+// Start renders progress periodically until Stop is called, nil progress
+// does nothing.
+func (p *progress) Start() {
+	if p == nil {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.render(false)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop renders final progress, it is safe to call Stop multiple times and on
+// nil progress.
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		p.render(true)
+	})
+}
+
+func (p *progress) event(done bool) progressEvent {
+	elapsed := p.now().Sub(p.start).Seconds()
+	e := progressEvent{
+		URL:        p.url,
+		Elapsed:    elapsed,
+		Downloaded: atomic.LoadInt64(&p.downloaded),
+		Done:       done,
+	}
+	if elapsed > 0 {
+		e.Rate = float64(e.Downloaded) / elapsed
+	}
+	if p.total > 0 {
+		e.Total = p.total
+		percent := float64(e.Downloaded) * 100 / float64(p.total)
+		e.Percent = &percent
+		if e.Rate > 0 {
+			eta := float64(p.total-e.Downloaded) / e.Rate
+			e.ETA = &eta
+		}
+	}
+	if p.chunk != nil {
+		chunk := p.chunk()
+		e.Chunk = &chunk
+	}
+	if p.upload {
+		uploaded := atomic.LoadInt64(&p.uploaded)
+		e.Uploaded = &uploaded
+	}
+	return e
+}
+
+func (p *progress) render(done bool) {
+	e := p.event(done)
+	if p.format == progressJSON {
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(p.w, "%s\n", b)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(formatBytes(e.Downloaded))
+	if e.Percent != nil {
+		fmt.Fprintf(&b, " / %s %3.0f%%", formatBytes(e.Total), *e.Percent)
+	}
+	fmt.Fprintf(&b, "  %s/s", formatBytes(int64(e.Rate)))
+	if e.ETA != nil && !done {
+		fmt.Fprintf(&b, "  ETA %s", formatETA(*e.ETA))
+	}
+	if e.Chunk != nil {
+		fmt.Fprintf(&b, "  chunk %d", *e.Chunk)
+	}
+	if e.Uploaded != nil {
+		fmt.Fprintf(&b, "  uploaded %s", formatBytes(*e.Uploaded))
+	}
+	line := b.String()
+	// pad by spaces to overwrite longer previous line
+	pad := p.width - len(line)
+	if pad < 0 {
+		pad = 0
+	}
+	p.width = len(line)
+	end := ""
+	if done {
+		end = "\n"
+	}
+	fmt.Fprintf(p.w, "\r%s%s%s", line, strings.Repeat(" ", pad), end)
+}
+
+type progressCounter struct {
+	n *int64
+}
+
+func (c progressCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.n, int64(len(p)))
+	return len(p), nil
+}
+
+// formatBytes formats n in binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA formats seconds as h:mm:ss or m:ss.
+func formatETA(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProgress(w io.Writer, format string, total int64) (*progress, *time.Time) {
+	p := newProgressWriter(w, format, total)
+	now := p.start
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func TestProgress_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	p, now := newTestProgress(&buf, progressJSON, 1000)
+	p.url = "http://example.com/file"
+	p.Chunk(func() int { return 2 })
+	upload := p.Uploaded(strings.NewReader("uploaded"))
+
+	p.Downloaded().Write(make([]byte, 250))
+	*now = now.Add(time.Second)
+	p.render(false)
+	io.Copy(io.Discard, upload)
+	p.Downloaded().Write(make([]byte, 750))
+	*now = now.Add(time.Second)
+	p.Stop()
+
+	var events []progressEvent
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var e progressEvent
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "http://example.com/file", events[0].URL)
+	assert.Equal(t, int64(250), events[0].Downloaded)
+	assert.Equal(t, int64(1000), events[0].Total)
+	assert.Equal(t, 25.0, *events[0].Percent)
+	assert.Equal(t, 250.0, events[0].Rate)
+	assert.Equal(t, 3.0, *events[0].ETA)
+	assert.Equal(t, 2, *events[0].Chunk)
+	assert.Equal(t, int64(0), *events[0].Uploaded)
+	assert.False(t, events[0].Done)
+
+	assert.Equal(t, 100.0, *events[1].Percent)
+	assert.Equal(t, int64(8), *events[1].Uploaded)
+	assert.True(t, events[1].Done)
+}
+
+func TestProgress_Bar(t *testing.T) {
+	var buf bytes.Buffer
+	p, now := newTestProgress(&buf, progressBar, 3<<20)
+	p.Downloaded().Write(make([]byte, 1<<20))
+	*now = now.Add(2 * time.Second)
+	p.render(false)
+	p.Stop()
+
+	lines := strings.Split(buf.String(), "\r")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "1.0 MiB / 3.0 MiB  33%  512.0 KiB/s  ETA 0:04", lines[1])
+	// final line overwrites longer line with ETA and ends the line
+	assert.Equal(t, "1.0 MiB / 3.0 MiB  33%  512.0 KiB/s", strings.TrimRight(lines[2], " \n"))
+	assert.Len(t, lines[2], len(lines[1])+1)
+	assert.True(t, strings.HasSuffix(lines[2], "\n"))
+}
+
+func TestProgress_UnknownSize(t *testing.T) {
+	var buf bytes.Buffer
+	p, now := newTestProgress(&buf, progressJSON, -1)
+	p.Downloaded().Write(make([]byte, 10))
+	*now = now.Add(time.Second)
+	p.Stop()
+
+	var e map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &e))
+	assert.NotContains(t, e, "percent")
+	assert.NotContains(t, e, "eta_seconds")
+	assert.NotContains(t, e, "chunk")
+	assert.NotContains(t, e, "uploaded")
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.4 MiB", formatBytes(floppySize))
+	assert.Equal(t, "4.0 GiB", formatBytes(4<<30))
+	assert.Equal(t, "1:05", formatETA(65))
+	assert.Equal(t, "2:00:01", formatETA(7201))
+}
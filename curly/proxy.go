@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseProxy parses -proxy URL, scheme defaults to http as in curl.
+func parseProxy(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, supported: http, https, socks5, socks5h", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", s)
+	}
+	return u, nil
+}
+
+// setProxyUser sets -proxy-user USER:PASSWORD credentials of proxy.
+func setProxyUser(proxy *url.URL, userPassword string) error {
+	i := strings.IndexByte(userPassword, ':')
+	if i <= 0 {
+		return fmt.Errorf("-proxy-user must be in format USER:PASSWORD")
+	}
+	proxy.User = url.UserPassword(userPassword[:i], userPassword[i+1:])
+	return nil
+}
+
+// parseNoProxy parses comma separated -noproxy list of hosts, domains, IP
+// addresses and CIDR ranges, * matches all hosts.
+func parseNoProxy(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// noProxyMatch reports whether host is excluded from proxying by noProxy list.
+// Domain matches the domain and all its subdomains, leading dot is optional.
+func noProxyMatch(host string, noProxy []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	ip := net.ParseIP(host)
+	for _, np := range noProxy {
+		switch {
+		case np == "*":
+			return true
+		case strings.Contains(np, "/"):
+			if _, cidr, err := net.ParseCIDR(np); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if npIP := net.ParseIP(np); npIP != nil && npIP.Equal(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(np, ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyFunc returns Proxy of http.Transport which sends requests through
+// -proxy, or through proxy from environment when -proxy is not set, except
+// -noproxy hosts.
+func proxyFunc(cfg *Config) func(*http.Request) (*url.URL, error) {
+	return func(r *http.Request) (*url.URL, error) {
+		if noProxyMatch(r.URL.Hostname(), cfg.NoProxy) {
+			return nil, nil
+		}
+		if cfg.Proxy != nil {
+			return cfg.Proxy, nil
+		}
+		return http.ProxyFromEnvironment(r)
+	}
+}
@@ -0,0 +1,140 @@
+// Package ratelimit throttles readers and writers by token bucket.
+//
+// Bucket is refilled continuously by rate bytes per second and holds at most
+// 100ms worth of tokens, so transfer is smooth instead of bursty. Reads and
+// writes larger than the bucket are split, tiny ones accumulate fractions of
+// tokens, so the rate is kept accurately for any buffer size.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by all readers and writers created by it,
+// it is safe for concurrent use.
+type Limiter struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewLimiter returns limiter of rate bytes per second, rate must be positive.
+func NewLimiter(rate int64) *Limiter {
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:  float64(rate),
+		burst: int(burst),
+		now:   time.Now,
+		sleep: sleep,
+	}
+}
+
+// Burst returns the most bytes which can be transferred at once.
+func (l *Limiter) Burst() int {
+	return l.burst
+}
+
+// WaitN blocks until n bytes can be transferred, n must not exceed Burst.
+// Tokens are reserved immediately, so concurrent callers are served in order.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	if l.last.IsZero() {
+		// bucket starts full
+		l.tokens = float64(l.burst)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+
+	if debt >= 0 {
+		return nil
+	}
+	return l.sleep(ctx, time.Duration(-debt/l.rate*float64(time.Second)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+// NewReader returns reader which reads r at rate of limiter l. Wait is
+// canceled with ctx.
+func NewReader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.l.burst {
+		p = p[:r.l.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+// NewWriter returns writer which writes into w at rate of limiter l. Wait is
+// canceled with ctx.
+func NewWriter(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	return &writer{ctx: ctx, w: w, l: l}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.l.burst {
+			n = w.l.burst
+		}
+		if err := w.l.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
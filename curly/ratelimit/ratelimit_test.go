@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock advances time by sleeps instead of sleeping.
+type fakeClock struct {
+	t time.Time
+}
+
+func newTestLimiter(rate int64) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLimiter(rate)
+	l.now = func() time.Time { return clock.t }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		clock.t = clock.t.Add(d)
+		return nil
+	}
+	return l, clock
+}
+
+func TestLimiter(t *testing.T) {
+	content := make([]byte, 100_000)
+	rand.New(rand.NewSource(1)).Read(content)
+	const rate = 10_000
+
+	tests := []struct {
+		name   string
+		buffer int
+		writer bool
+	}{
+		{name: "tiny buffer reader", buffer: 1},
+		{name: "odd buffer reader", buffer: 777},
+		{name: "huge buffer reader", buffer: 1 << 20},
+		{name: "tiny buffer writer", buffer: 1, writer: true},
+		{name: "huge buffer writer", buffer: 1 << 20, writer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter(rate)
+			start := clock.t
+			var got bytes.Buffer
+			var err error
+			if tt.writer {
+				_, err = io.CopyBuffer(NewWriter(context.Background(), &got, l), onlyReader{bytes.NewReader(content)}, make([]byte, tt.buffer))
+			} else {
+				_, err = io.CopyBuffer(onlyWriter{&got}, NewReader(context.Background(), bytes.NewReader(content), l), make([]byte, tt.buffer))
+			}
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(content, got.Bytes()))
+
+			// the first burst is free, the rest is transferred at rate
+			want := time.Duration(float64(len(content)-l.Burst()) / rate * float64(time.Second))
+			assert.InDelta(t, want.Seconds(), clock.t.Sub(start).Seconds(), 0.01)
+		})
+	}
+}
+
+func TestLimiter_Smooth(t *testing.T) {
+	l, clock := newTestLimiter(10_000)
+	r := NewReader(context.Background(), bytes.NewReader(make([]byte, 50_000)), l)
+	buf := make([]byte, 1<<20)
+	last := clock.t
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		// no read waits longer than 100ms
+		assert.LessOrEqual(t, n, l.Burst())
+		assert.LessOrEqual(t, clock.t.Sub(last), 100*time.Millisecond+time.Millisecond)
+		last = clock.t
+	}
+}
+
+func TestLimiter_RealTime(t *testing.T) {
+	l := NewLimiter(100_000)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, NewReader(context.Background(), bytes.NewReader(make([]byte, 50_000)), l))
+	require.NoError(t, err)
+	assert.Equal(t, int64(50_000), n)
+	assert.InDelta(t, 0.4, time.Since(start).Seconds(), 0.2)
+}
+
+func TestLimiter_Canceled(t *testing.T) {
+	l := NewLimiter(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := io.Copy(io.Discard, NewReader(ctx, bytes.NewReader(make([]byte, 1000)), l))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// onlyReader and onlyWriter hide ReaderFrom and WriterTo so io.CopyBuffer
+// uses the buffer.
+type onlyReader struct{ io.Reader }
+
+type onlyWriter struct{ io.Writer }
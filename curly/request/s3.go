@@ -0,0 +1,305 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/sigv4"
+)
+
+const (
+	defaultS3PartSize   = 8 << 20
+	defaultS3MaxRetries = 3
+	defaultS3Backoff    = time.Second
+
+	// s3MaxParts is the most parts of multipart upload.
+	s3MaxParts = 10000
+	// s3MaxBody is the most bytes of S3 response read into memory.
+	s3MaxBody = 1 << 20
+)
+
+// S3Options configures NewS3Uploader.
+type S3Options struct {
+	// Endpoint is URL of S3-compatible storage, buckets are addressed by
+	// path. Default is AWS endpoint of Region with virtual-hosted buckets.
+	Endpoint    string
+	Region      string
+	Credentials sigv4.Credentials
+}
+
+// S3Uploader streams content into S3 object. Content of one part is read
+// into memory, it is sent by single PUT request, larger content is sent by
+// multipart upload. Failed requests are retried by the uploader, so client
+// should not retry them, multipart upload which can not be completed is
+// aborted.
+type S3Uploader struct {
+	// PartSize is size of multipart upload part, S3 requires at least 5 MiB.
+	PartSize int
+	// MaxRetries is number of retries of failed request.
+	MaxRetries int
+	// Backoff is delay before retry, it doubles with every retry.
+	Backoff time.Duration
+
+	c         *http.Client
+	endpoint  *url.URL
+	pathStyle bool
+	signer    *sigv4.Signer
+	now       func() time.Time
+}
+
+// NewS3Uploader returns uploader signing requests by credentials of opts.
+func NewS3Uploader(c *http.Client, opts S3Options) (*S3Uploader, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+	if opts.Region == "" {
+		return nil, fmt.Errorf("region is empty")
+	}
+	if opts.Credentials.AccessKeyID == "" || opts.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("credentials are empty")
+	}
+	u := &S3Uploader{
+		PartSize:   defaultS3PartSize,
+		MaxRetries: defaultS3MaxRetries,
+		Backoff:    defaultS3Backoff,
+		c:          c,
+		signer:     &sigv4.Signer{Credentials: opts.Credentials, Region: opts.Region, Service: "s3"},
+		now:        time.Now,
+	}
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	} else {
+		u.pathStyle = true
+	}
+	var err error
+	if u.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("unable to parse S3 endpoint: %w", err)
+	}
+	if u.endpoint.Scheme == "" || u.endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint %q is not absolute URL", endpoint)
+	}
+	return u, nil
+}
+
+// S3Error is returned for unexpected status of S3 request, Code and Message
+// are parsed from error response.
+type S3Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 %s %s: unexpected status %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3 %s %s: %s: %s", e.Method, e.URL, e.Code, e.Message)
+}
+
+type s3ErrorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// Upload stores content of r into object key of bucket. It returns URL of
+// the object.
+func (u *S3Uploader) Upload(ctx context.Context, bucket, key string, r io.Reader) (string, error) {
+	partSize := u.PartSize
+	if partSize <= 0 {
+		partSize = defaultS3PartSize
+	}
+	object := u.objectURL(bucket, key)
+
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		// content fits into one part
+		if _, err := u.do(ctx, http.MethodPut, object, nil, buf[:n]); err != nil {
+			return "", err
+		}
+		return object.String(), nil
+	case err != nil:
+		return "", fmt.Errorf("unable to read upload content: %w", err)
+	}
+
+	uploadID, err := u.create(ctx, object)
+	if err != nil {
+		return "", err
+	}
+	if err := u.multipart(ctx, object, uploadID, r, buf); err != nil {
+		// ctx may be already canceled
+		if aerr := u.abort(context.Background(), object, uploadID); aerr != nil {
+			return "", fmt.Errorf("%w, multipart upload %s is not aborted: %v", err, uploadID, aerr)
+		}
+		return "", err
+	}
+	return object.String(), nil
+}
+
+// multipart sends buf as the first part followed by parts read from r and
+// completes the upload.
+func (u *S3Uploader) multipart(ctx context.Context, object *url.URL, uploadID string, r io.Reader, buf []byte) error {
+	var parts []completedPart
+	for n := len(buf); n > 0; {
+		if len(parts) == s3MaxParts {
+			return fmt.Errorf("upload is larger than %d parts of %d bytes", s3MaxParts, len(buf))
+		}
+		number := len(parts) + 1
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := u.do(ctx, http.MethodPut, object, query, buf[:n])
+		if err != nil {
+			return fmt.Errorf("unable to upload part %d: %w", number, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("unable to read upload content: %w", err)
+		}
+	}
+
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := u.do(ctx, http.MethodPost, object, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return fmt.Errorf("unable to complete multipart upload: %w", err)
+	}
+	// complete returns 200 OK with error body when it fails after the
+	// response has been started
+	var e s3ErrorResponse
+	if xml.Unmarshal(resp.body, &e) == nil {
+		return &S3Error{Method: http.MethodPost, URL: object.String(), StatusCode: resp.StatusCode, Code: e.Code, Message: e.Message}
+	}
+	return nil
+}
+
+func (u *S3Uploader) create(ctx context.Context, object *url.URL) (string, error) {
+	resp, err := u.do(ctx, http.MethodPost, object, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to create multipart upload: %w", err)
+	}
+	var result initiateMultipartUploadResult
+	if err := xml.Unmarshal(resp.body, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("create multipart upload returned invalid response: %q", resp.body)
+	}
+	return result.UploadID, nil
+}
+
+func (u *S3Uploader) abort(ctx context.Context, object *url.URL, uploadID string) error {
+	_, err := u.do(ctx, http.MethodDelete, object, url.Values{"uploadId": {uploadID}}, nil)
+	return err
+}
+
+// objectURL returns URL of key in bucket.
+func (u *S3Uploader) objectURL(bucket, key string) *url.URL {
+	object := *u.endpoint
+	if u.pathStyle {
+		object.Path = fmt.Sprintf("%s/%s/%s", strings.TrimRight(object.Path, "/"), bucket, key)
+	} else {
+		object.Host = bucket + "." + object.Host
+		object.Path = "/" + key
+	}
+	object.RawPath = ""
+	return &object
+}
+
+// s3Response is response with read body.
+type s3Response struct {
+	*http.Response
+	body []byte
+}
+
+// do sends signed request with body, it is retried with backoff when it
+// fails by connection error, 5xx status or throttling.
+func (u *S3Uploader) do(ctx context.Context, method string, object *url.URL, query url.Values, body []byte) (*s3Response, error) {
+	target := *object
+	target.RawQuery = query.Encode()
+	payloadHash := sigv4.PayloadHash(body)
+	backoff := u.Backoff
+	for retries := 0; ; retries++ {
+		resp, err := u.send(ctx, method, target, body, payloadHash)
+		if err == nil || retries >= u.MaxRetries || !s3Retryable(ctx, err) {
+			return resp, err
+		}
+		if err := sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+func (u *S3Uploader) send(ctx context.Context, method string, target url.URL, body []byte, payloadHash string) (*s3Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = http.NoBody
+		req.GetBody = nil
+		req.ContentLength = 0
+	}
+	u.signer.Sign(req, payloadHash, u.now())
+
+	resp, err := u.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, s3MaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		se := &S3Error{Method: method, URL: req.URL.String(), StatusCode: resp.StatusCode}
+		var e s3ErrorResponse
+		if xml.Unmarshal(b, &e) == nil {
+			se.Code, se.Message = e.Code, e.Message
+		}
+		return nil, se
+	}
+	return &s3Response{Response: resp, body: b}, nil
+}
+
+// s3Retryable returns true for connection errors, 5xx status, throttling and
+// request timeout.
+func s3Retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *S3Error
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 || se.StatusCode == http.StatusTooManyRequests ||
+			se.Code == "RequestTimeout" || se.Code == "SlowDown"
+	}
+	return connectionError(err)
+}
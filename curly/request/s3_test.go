@@ -0,0 +1,137 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/s3test"
+)
+
+func newTestS3Uploader(t *testing.T, s *s3test.Server) *S3Uploader {
+	u, err := NewS3Uploader(s.Client(), S3Options{
+		Endpoint:    s.URL,
+		Region:      s3test.Region,
+		Credentials: s3test.Credentials,
+	})
+	require.NoError(t, err)
+	u.PartSize = 1000
+	u.Backoff = time.Millisecond
+	return u
+}
+
+func TestS3Uploader_Upload(t *testing.T) {
+	content := make([]byte, 3500)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name    string
+		key     string
+		content []byte
+	}{
+		{name: "multipart", key: "dir/file.bin", content: content},
+		{name: "multiple of part size", key: "file.bin", content: content[:3000]},
+		{name: "single part", key: "file.bin", content: content[:999]},
+		{name: "empty", key: "file.bin", content: nil},
+		{name: "escaped key", key: "dir/a+b c%.bin", content: content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := s3test.NewServer()
+			defer s.Close()
+			s.MinPartSize = 1000
+			u := newTestS3Uploader(t, s)
+
+			location, err := u.Upload(context.Background(), "bucket", tt.key, bytes.NewReader(tt.content))
+			require.NoError(t, err)
+			assert.Contains(t, location, s.URL+"/bucket/")
+
+			got, ok := s.Object("bucket", tt.key)
+			require.True(t, ok)
+			assert.True(t, bytes.Equal(tt.content, got))
+			assert.Empty(t, s.Uploads())
+		})
+	}
+}
+
+func TestS3Uploader_Retry(t *testing.T) {
+	content := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(content)
+	s := s3test.NewServer()
+	defer s.Close()
+	s.FailPart(func(part, attempt int) int {
+		if part == 2 && attempt <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+
+	u := newTestS3Uploader(t, s)
+	_, err := u.Upload(context.Background(), "bucket", "file.bin", bytes.NewReader(content))
+	require.NoError(t, err)
+	got, _ := s.Object("bucket", "file.bin")
+	assert.True(t, bytes.Equal(content, got))
+}
+
+func TestS3Uploader_Abort(t *testing.T) {
+	s := s3test.NewServer()
+	defer s.Close()
+	s.FailPart(func(part, _ int) int {
+		if part == 2 {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+
+	u := newTestS3Uploader(t, s)
+	u.MaxRetries = 2
+	_, err := u.Upload(context.Background(), "bucket", "file.bin", bytes.NewReader(make([]byte, 2500)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to upload part 2")
+	var se *S3Error
+	require.True(t, errors.As(err, &se), err)
+	assert.Equal(t, "InternalError", se.Code)
+
+	_, ok := s.Object("bucket", "file.bin")
+	assert.False(t, ok)
+	assert.Empty(t, s.Uploads())
+	assert.Equal(t, []string{"upload-1"}, s.Aborted())
+}
+
+func TestS3Uploader_Errors(t *testing.T) {
+	s := s3test.NewServer()
+	defer s.Close()
+
+	creds := s3test.Credentials
+	creds.SecretAccessKey = "wrong"
+	u, err := NewS3Uploader(s.Client(), S3Options{Endpoint: s.URL, Region: s3test.Region, Credentials: creds})
+	require.NoError(t, err)
+	u.Backoff = time.Millisecond
+	_, err = u.Upload(context.Background(), "bucket", "file.bin", bytes.NewReader([]byte("content")))
+	var se *S3Error
+	require.True(t, errors.As(err, &se), err)
+	assert.Equal(t, http.StatusForbidden, se.StatusCode)
+	assert.Equal(t, "SignatureDoesNotMatch", se.Code)
+
+	_, err = NewS3Uploader(s.Client(), S3Options{Endpoint: s.URL, Region: s3test.Region})
+	assert.EqualError(t, err, "credentials are empty")
+	_, err = NewS3Uploader(s.Client(), S3Options{Endpoint: "localhost", Region: s3test.Region, Credentials: s3test.Credentials})
+	assert.EqualError(t, err, `S3 endpoint "localhost" is not absolute URL`)
+}
+
+func TestS3Uploader_objectURL(t *testing.T) {
+	u, err := NewS3Uploader(http.DefaultClient, S3Options{Region: "eu-central-1", Credentials: s3test.Credentials})
+	require.NoError(t, err)
+	assert.Equal(t, "https://bucket.s3.eu-central-1.amazonaws.com/dir/file.bin", u.objectURL("bucket", "dir/file.bin").String())
+
+	u, err = NewS3Uploader(http.DefaultClient, S3Options{Endpoint: "http://localhost:9000/minio/", Region: "us-east-1", Credentials: s3test.Credentials})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000/minio/bucket/dir/file.bin", u.objectURL("bucket", "dir/file.bin").String())
+}
@@ -0,0 +1,262 @@
+// Package s3test provides in-process fake of S3 object and multipart upload
+// API for tests. Buckets are addressed by path and exist implicitly, requests
+// must be signed by AWS Signature Version 4.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/sigv4"
+)
+
+// Region of Server.
+const Region = "us-east-1"
+
+// Credentials accepted by Server.
+var Credentials = sigv4.Credentials{
+	AccessKeyID:     "AKIAS3TEST",
+	SecretAccessKey: "s3test-secret",
+}
+
+// Server is fake S3 storage.
+type Server struct {
+	*httptest.Server
+	// MinPartSize is the least size of part which is not last, S3 requires
+	// 5 MiB, tests use smaller parts.
+	MinPartSize int
+
+	signer *sigv4.Signer
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]*upload
+	next     int
+	attempts map[int]int
+	fail     func(part, attempt int) int
+	delay    time.Duration
+	aborted  []string
+}
+
+type upload struct {
+	object string
+	parts  map[int][]byte
+}
+
+// NewServer starts Server.
+func NewServer() *Server {
+	s := &Server{
+		MinPartSize: 1,
+		signer:      &sigv4.Signer{Credentials: Credentials, Region: Region, Service: "s3"},
+		objects:     make(map[string][]byte),
+		uploads:     make(map[string]*upload),
+		attempts:    make(map[int]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// FailPart makes server respond to upload of part by status returned by fail,
+// zero status handles the request. attempt starts at 1 for every part number.
+func (s *Server) FailPart(fail func(part, attempt int) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// DelayPart makes server wait d before it stores uploaded part, so uploads
+// are slow.
+func (s *Server) DelayPart(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Object returns content of key in bucket.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects[bucket+"/"+key]
+	return b, ok
+}
+
+// Uploads returns IDs of multipart uploads in progress.
+func (s *Server) Uploads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.uploads))
+	for id := range s.uploads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Aborted returns IDs of aborted multipart uploads.
+func (s *Server) Aborted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.aborted...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if err := s.signer.Verify(r); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if h := r.Header.Get("X-Amz-Content-Sha256"); h != sigv4.UnsignedPayload && h != sigv4.PayloadHash(body) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash does not match body")
+		return
+	}
+	object := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.IndexByte(object, '/'); i <= 0 || i == len(object)-1 {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "path must be /bucket/key")
+		return
+	}
+
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	switch {
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		s.uploadPart(w, query.Get("uploadId"), query.Get("partNumber"), body)
+	case r.Method == http.MethodPut:
+		s.mu.Lock()
+		s.objects[object] = body
+		s.mu.Unlock()
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && uploads:
+		s.mu.Lock()
+		s.next++
+		id := fmt.Sprintf("upload-%d", s.next)
+		s.uploads[id] = &upload{object: object, parts: make(map[int][]byte)}
+		s.mu.Unlock()
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Key      string   `xml:"Key"`
+			UploadID string   `xml:"UploadId"`
+		}{Key: object, UploadID: id})
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.complete(w, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.uploads[query.Get("uploadId")]; !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "upload does not exist")
+			return
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		s.aborted = append(s.aborted, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not implemented")
+	}
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, id, partNumber string, body []byte) {
+	part, err := strconv.Atoi(partNumber)
+	if err != nil || part < 1 || part > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid partNumber")
+		return
+	}
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "upload does not exist")
+		return
+	}
+	s.attempts[part]++
+	if s.fail != nil {
+		if status := s.fail(part, s.attempts[part]); status != 0 {
+			writeError(w, status, "InternalError", "injected failure")
+			return
+		}
+	}
+	u.parts[part] = body
+	w.Header().Set("ETag", etag(body))
+}
+
+func (s *Server) complete(w http.ResponseWriter, id string, body []byte) {
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid CompleteMultipartUpload")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "upload does not exist")
+		return
+	}
+	var content bytes.Buffer
+	for i, p := range req.Parts {
+		b, ok := u.parts[p.PartNumber]
+		switch {
+		case !ok || etag(b) != p.ETag:
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d does not match", p.PartNumber))
+			return
+		case i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber:
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+			return
+		case i < len(req.Parts)-1 && len(b) < s.MinPartSize:
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is too small", p.PartNumber))
+			return
+		}
+		content.Write(b)
+	}
+	s.objects[u.object] = content.Bytes()
+	delete(s.uploads, id)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string   `xml:"Key"`
+	}{Key: u.object})
+}
+
+func etag(b []byte) string {
+	h := md5.Sum(b)
+	return `"` + hex.EncodeToString(h[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
@@ -0,0 +1,196 @@
+// Package sigv4 signs HTTP requests by AWS Signature Version 4, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_aws-signing.html.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	// TimeFormat is format of X-Amz-Date header.
+	TimeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// UnsignedPayload is payload hash of request which body is not signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// Credentials are AWS access keys, SessionToken is set for temporary
+// credentials only.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Signer signs requests of Service in Region, e.g. s3 in us-east-1.
+type Signer struct {
+	Credentials Credentials
+	Region      string
+	Service     string
+}
+
+// PayloadHash returns hex encoded SHA-256 of request body b.
+func PayloadHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Sign sets X-Amz-Date, X-Amz-Content-Sha256, X-Amz-Security-Token and
+// Authorization headers of req signed at t. Host and all headers set on req
+// are signed, so headers must not be changed after Sign. payloadHash is
+// PayloadHash of the body or UnsignedPayload.
+func (s *Signer) Sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+	// path is sent encoded as it is signed
+	req.URL.RawPath = escapePath(req.URL.Path)
+
+	signedHeaders := []string{"host"}
+	for name := range req.Header {
+		if name = strings.ToLower(name); name != "authorization" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	sort.Strings(signedHeaders)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.Credentials.AccessKeyID, s.scope(t), strings.Join(signedHeaders, ";"),
+		s.signature(req, signedHeaders, payloadHash, t)))
+}
+
+// Verify checks Authorization header of req received by server. Payload hash
+// is taken from X-Amz-Content-Sha256 header, it is not compared with the body.
+func (s *Signer) Verify(req *http.Request) error {
+	auth := strings.TrimPrefix(req.Header.Get("Authorization"), algorithm+" ")
+	if auth == req.Header.Get("Authorization") {
+		return errors.New("missing " + algorithm + " Authorization")
+	}
+	fields := make(map[string]string)
+	for _, f := range strings.Split(auth, ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	t, err := time.Parse(TimeFormat, req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date: %w", err)
+	}
+	if want := s.Credentials.AccessKeyID + "/" + s.scope(t); fields["Credential"] != want {
+		return fmt.Errorf("credential %q does not match %q", fields["Credential"], want)
+	}
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	signature := s.signature(req, signedHeaders, req.Header.Get("X-Amz-Content-Sha256"), t)
+	if !hmac.Equal([]byte(signature), []byte(fields["Signature"])) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func (s *Signer) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(dateFormat), s.Region, s.Service, "aws4_request"}, "/")
+}
+
+// signature returns hex encoded signature of canonical request.
+func (s *Signer) signature(req *http.Request, signedHeaders []string, payloadHash string, t time.Time) string {
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(escapePath(req.URL.Path) + "\n")
+	canonical.WriteString(canonicalQuery(req) + "\n")
+	for _, name := range signedHeaders {
+		canonical.WriteString(name + ":" + headerValue(req, name) + "\n")
+	}
+	canonical.WriteString("\n" + strings.Join(signedHeaders, ";") + "\n")
+	canonical.WriteString(payloadHash)
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		t.Format(TimeFormat),
+		s.scope(t),
+		PayloadHash([]byte(canonical.String())),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.Credentials.SecretAccessKey), t.Format(dateFormat))
+	for _, v := range []string{s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// headerValue returns values of header name joined by comma with trimmed and
+// collapsed spaces.
+func headerValue(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	values := req.Header.Values(name)
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func escapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return escape(p, false)
+}
+
+// escape percent-encodes all bytes except unreserved characters of RFC 3986,
+// slash is encoded when encodeSlash is true.
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,305 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// TusVersion is version of tus resumable upload protocol sent in
+// Tus-Resumable header, see https://tus.io/protocols/resumable-upload.
+const TusVersion = "1.0.0"
+
+const (
+	defaultTusChunkSize  = 4 << 20
+	defaultTusMaxRetries = 3
+	defaultTusBackoff    = time.Second
+)
+
+// TusUploader uploads content by tus 1.0 protocol with creation,
+// creation-defer-length and termination extensions. Content is sent by PATCH
+// requests of ChunkSize bytes, failed PATCH is resumed from offset returned by
+// HEAD request, so dropped connection loses at most one chunk.
+type TusUploader struct {
+	// ChunkSize is size of PATCH request body, the chunk is kept in memory
+	// until it is acknowledged by server.
+	ChunkSize int
+	// MaxRetries is number of resumes of failed chunk.
+	MaxRetries int
+	// Backoff is delay before resume, it doubles with every retry.
+	Backoff time.Duration
+
+	c        *http.Client
+	endpoint *url.URL
+}
+
+// NewTusUploader returns uploader which creates uploads at tus endpoint URL.
+func NewTusUploader(c *http.Client, endpoint string) (*TusUploader, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("endpoint is empty")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse tus endpoint: %w", err)
+	}
+	return &TusUploader{
+		ChunkSize:  defaultTusChunkSize,
+		MaxRetries: defaultTusMaxRetries,
+		Backoff:    defaultTusBackoff,
+		c:          c,
+		endpoint:   u,
+	}, nil
+}
+
+// TusStatusError is returned for unexpected status of tus request.
+type TusStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *TusStatusError) Error() string {
+	return fmt.Sprintf("tus %s %s: unexpected status %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Upload creates upload of r named filename and sends the content. size of
+// content is declared in creation, -1 defers it until r is read. It returns
+// URL of the upload, upload which can not be finished is terminated.
+func (u *TusUploader) Upload(ctx context.Context, filename string, r io.Reader, size int64) (string, error) {
+	location, err := u.Create(ctx, filename, size)
+	if err != nil {
+		return "", err
+	}
+	if err := u.send(ctx, location, r, size); err != nil {
+		// ctx may be already canceled
+		if terr := u.Terminate(context.Background(), location); terr != nil {
+			return "", fmt.Errorf("%w, upload is not terminated: %v", err, terr)
+		}
+		return "", err
+	}
+	return location, nil
+}
+
+// Create creates upload of size bytes, -1 defers the size. It returns URL of
+// the upload.
+func (u *TusUploader) Create(ctx context.Context, filename string, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.endpoint.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Tus-Resumable", TusVersion)
+	if size >= 0 {
+		req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	} else {
+		req.Header.Set("Upload-Defer-Length", "1")
+	}
+	if filename != "" {
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename)))
+	}
+	resp, err := u.do(req, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	location, err := u.endpoint.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return "", fmt.Errorf("tus creation returned invalid Location %q", resp.Header.Get("Location"))
+	}
+	return location.String(), nil
+}
+
+// Offset returns number of bytes of upload stored by server.
+func (u *TusUploader) Offset(ctx context.Context, location string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", TusVersion)
+	req.Header.Set("Cache-Control", "no-store")
+	resp, err := u.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return 0, err
+	}
+	return uploadOffset(resp)
+}
+
+// Terminate removes upload from server.
+func (u *TusUploader) Terminate(ctx context.Context, location string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, location, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Tus-Resumable", TusVersion)
+	_, err = u.do(req, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+// send reads r by chunks and sends them to upload at location.
+func (u *TusUploader) send(ctx context.Context, location string, r io.Reader, size int64) error {
+	chunkSize := u.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultTusChunkSize
+	}
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := false
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			last = true
+		case err != nil:
+			return fmt.Errorf("unable to read upload content: %w", err)
+		}
+		end := offset + int64(n)
+		length := int64(-1)
+		switch {
+		case size >= 0 && end > size:
+			return fmt.Errorf("upload content is longer than %d bytes", size)
+		case size >= 0 && last && end < size:
+			return fmt.Errorf("upload content has %d bytes, %d expected", end, size)
+		case size >= 0 && end == size:
+			last = true
+		case size < 0 && last:
+			// deferred length is declared by the last PATCH
+			length = end
+		}
+		if n > 0 || length >= 0 {
+			if err := u.sendChunk(ctx, location, offset, buf[:n], length); err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+		offset = end
+	}
+}
+
+// sendChunk sends chunk starting at offset, it is resumed from offset stored
+// by server when PATCH fails. length is declared when it is not -1.
+func (u *TusUploader) sendChunk(ctx context.Context, location string, offset int64, chunk []byte, length int64) error {
+	start, end := offset, offset+int64(len(chunk))
+	backoff := u.Backoff
+	var err error
+	for retries := 0; ; {
+		if err != nil {
+			if retries >= u.MaxRetries || !resumable(ctx, err) {
+				return err
+			}
+			retries++
+			if err := sleep(ctx, backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+			stored, herr := u.Offset(ctx, location)
+			if herr != nil {
+				err = fmt.Errorf("unable to resume upload: %w", herr)
+				continue
+			}
+			if stored < start || stored > end {
+				return fmt.Errorf("unable to resume upload, server has %d bytes, chunk %d-%d is buffered", stored, start, end)
+			}
+			offset = stored
+		}
+
+		var next int64
+		next, err = u.patch(ctx, location, offset, chunk[offset-start:], length)
+		if err != nil {
+			continue
+		}
+		if next == end {
+			return nil
+		}
+		if next <= offset || next > end {
+			return fmt.Errorf("tus PATCH %s returned Upload-Offset %d, expected %d", location, next, end)
+		}
+		// server stored only part of the chunk
+		offset = next
+	}
+}
+
+func (u *TusUploader) patch(ctx context.Context, location string, offset int64, b []byte, length int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, location, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", TusVersion)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	if length >= 0 {
+		req.Header.Set("Upload-Length", strconv.FormatInt(length, 10))
+	}
+	resp, err := u.do(req, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	return uploadOffset(resp)
+}
+
+// do sends request and returns response with closed body, status of the
+// response must be one of status.
+func (u *TusUploader) do(req *http.Request, status ...int) (*http.Response, error) {
+	resp, err := u.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	for _, s := range status {
+		if resp.StatusCode == s {
+			return resp, nil
+		}
+	}
+	return nil, &TusStatusError{Method: req.Method, URL: req.URL.String(), StatusCode: resp.StatusCode}
+}
+
+func uploadOffset(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("tus %s returned invalid Upload-Offset %q", resp.Request.Method, resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
+
+// resumable returns true for connection errors, conflicting offset and server
+// errors.
+func resumable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *TusStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusConflict || se.StatusCode == http.StatusLocked || se.StatusCode >= 500
+	}
+	return connectionError(err)
+}
+
+// connectionError returns true for errors of sent request which did not
+// return response.
+func connectionError(err error) bool {
+	var ne net.Error
+	var ue *url.Error
+	return errors.As(err, &ne) || errors.As(err, &ue)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/tustest"
+)
+
+func newTestTusUploader(t *testing.T, s *tustest.Server) *TusUploader {
+	u, err := NewTusUploader(s.Client(), s.Endpoint())
+	require.NoError(t, err)
+	u.ChunkSize = 1000
+	u.Backoff = time.Millisecond
+	return u
+}
+
+func TestTusUploader_Upload(t *testing.T) {
+	content := make([]byte, 3500)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name    string
+		content []byte
+		size    int64
+	}{
+		{name: "known size", content: content, size: int64(len(content))},
+		{name: "deferred size", content: content, size: -1},
+		{name: "deferred size multiple of chunk", content: content[:3000], size: -1},
+		{name: "empty", content: nil, size: 0},
+		{name: "empty deferred", content: nil, size: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tustest.NewServer()
+			defer s.Close()
+			u := newTestTusUploader(t, s)
+
+			location, err := u.Upload(context.Background(), "file.bin", bytes.NewReader(tt.content), tt.size)
+			require.NoError(t, err)
+			assert.Equal(t, s.URL+tustest.BasePath+"1", location)
+
+			uploads := s.Uploads()
+			require.Len(t, uploads, 1)
+			assert.Equal(t, int64(len(tt.content)), uploads[0].Length)
+			assert.Equal(t, len(tt.content), len(uploads[0].Data))
+			assert.True(t, bytes.Equal(tt.content, uploads[0].Data))
+			assert.Equal(t, map[string]string{"filename": "file.bin"}, uploads[0].Metadata)
+		})
+	}
+}
+
+func TestTusUploader_Resume(t *testing.T) {
+	content := make([]byte, 3500)
+	rand.New(rand.NewSource(1)).Read(content)
+	s := tustest.NewServer()
+	defer s.Close()
+
+	// connection is dropped in the middle of the second and the last chunk
+	drops := map[int64]int{1000: 300, 3000: 0}
+	s.DropPatch(func(_ *tustest.Upload, offset int64) int {
+		if n, ok := drops[offset]; ok {
+			delete(drops, offset)
+			return n
+		}
+		return -1
+	})
+
+	u := newTestTusUploader(t, s)
+	_, err := u.Upload(context.Background(), "file.bin", bytes.NewReader(content), -1)
+	require.NoError(t, err)
+
+	uploads := s.Uploads()
+	require.Len(t, uploads, 1)
+	assert.True(t, bytes.Equal(content, uploads[0].Data))
+	assert.Equal(t, int64(len(content)), uploads[0].Length)
+	assert.Empty(t, drops)
+}
+
+func TestTusUploader_Terminate(t *testing.T) {
+	s := tustest.NewServer()
+	defer s.Close()
+	s.DropPatch(func(*tustest.Upload, int64) int { return 10 })
+
+	u := newTestTusUploader(t, s)
+	u.MaxRetries = 2
+	_, err := u.Upload(context.Background(), "file.bin", strings.NewReader(strings.Repeat("a", 2500)), 2500)
+	require.Error(t, err)
+
+	assert.Empty(t, s.Uploads())
+	assert.Equal(t, []string{"1"}, s.Terminated())
+}
+
+func TestTusUploader_Errors(t *testing.T) {
+	s := tustest.NewServer()
+	defer s.Close()
+	u := newTestTusUploader(t, s)
+
+	_, err := u.Upload(context.Background(), "file.bin", strings.NewReader("short"), 10)
+	assert.EqualError(t, err, "upload content has 5 bytes, 10 expected")
+	_, err = u.Upload(context.Background(), "file.bin", strings.NewReader(strings.Repeat("a", 1500)), 1200)
+	assert.EqualError(t, err, "upload content is longer than 1200 bytes")
+	assert.Equal(t, []string{"1", "2"}, s.Terminated())
+
+	_, err = u.Offset(context.Background(), s.URL+tustest.BasePath+"missing")
+	var se *TusStatusError
+	require.True(t, errors.As(err, &se), err)
+	assert.Equal(t, http.StatusNotFound, se.StatusCode)
+
+	u, err = NewTusUploader(s.Client(), s.URL+"/not-tus")
+	require.NoError(t, err)
+	_, err = u.Create(context.Background(), "file.bin", 1)
+	require.True(t, errors.As(err, &se), err)
+
+	_, err = NewTusUploader(nil, s.Endpoint())
+	assert.Error(t, err)
+}
+
+func TestGZIPReader(t *testing.T) {
+	r := GZIPReader(strings.NewReader("hello"), nil)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(out))
+}
@@ -0,0 +1,257 @@
+// Package tustest provides in-process tus 1.0 server for tests of resumable
+// uploads, see https://tus.io/protocols/resumable-upload.
+package tustest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	version = "1.0.0"
+	// BasePath is path of creation endpoint, uploads are created under it.
+	BasePath = "/files/"
+)
+
+// Upload is upload stored by Server.
+type Upload struct {
+	ID       string
+	Metadata map[string]string
+	// Length is -1 until deferred length is declared.
+	Length int64
+	Data   []byte
+}
+
+// Server implements core protocol with creation, creation-defer-length and
+// termination extensions.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	uploads map[string]*Upload
+	next    int
+	// drop returns number of bytes of PATCH body stored before connection is
+	// dropped, negative value handles the request normally.
+	drop       func(u *Upload, offset int64) int
+	delay      time.Duration
+	terminated []string
+}
+
+// NewServer starts Server, Endpoint returns its creation URL.
+func NewServer() *Server {
+	s := &Server{uploads: make(map[string]*Upload)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Endpoint returns URL where uploads are created.
+func (s *Server) Endpoint() string {
+	return s.URL + BasePath
+}
+
+// DropPatch makes server drop connection of PATCH request after n bytes of
+// its body are stored when drop returns non-negative n.
+func (s *Server) DropPatch(drop func(u *Upload, offset int64) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop = drop
+}
+
+// DelayPatch makes server wait d before it handles PATCH request, so uploads
+// are slow.
+func (s *Server) DelayPatch(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Uploads returns copies of stored uploads.
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]Upload, 0, len(s.uploads))
+	for i := 1; i <= s.next; i++ {
+		if u, ok := s.uploads[strconv.Itoa(i)]; ok {
+			c := *u
+			c.Data = append([]byte(nil), u.Data...)
+			uploads = append(uploads, c)
+		}
+	}
+	return uploads
+}
+
+// Terminated returns IDs of terminated uploads.
+func (s *Server) Terminated() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.terminated...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", version)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", version)
+		w.Header().Set("Tus-Extension", "creation,creation-defer-length,termination")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != version {
+		w.Header().Set("Tus-Version", version)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if r.URL.Path == BasePath {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.create(w, r)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, BasePath)
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(u.Data)))
+		if u.Length >= 0 {
+			w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+		} else {
+			w.Header().Set("Upload-Defer-Length", "1")
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		s.patch(w, r, u)
+	case http.MethodDelete:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.uploads, id)
+		s.terminated = append(s.terminated, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	u := &Upload{Length: -1, Metadata: make(map[string]string)}
+	switch {
+	case r.Header.Get("Upload-Length") != "":
+		n, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		u.Length = n
+	case r.Header.Get("Upload-Defer-Length") != "1":
+		http.Error(w, "Upload-Length or Upload-Defer-Length is required", http.StatusBadRequest)
+		return
+	}
+	for _, pair := range strings.Split(r.Header.Get("Upload-Metadata"), ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+				return
+			}
+		}
+		u.Metadata[fields[0]] = string(value)
+	}
+
+	s.mu.Lock()
+	s.next++
+	u.ID = strconv.Itoa(s.next)
+	s.uploads[u.ID] = u
+	s.mu.Unlock()
+
+	// relative location is resolved by client
+	w.Header().Set("Location", BasePath+u.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request, u *Upload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if offset != int64(len(u.Data)) {
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if v := r.Header.Get("Upload-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || (u.Length >= 0 && u.Length != n) {
+			s.mu.Unlock()
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		u.Length = n
+	}
+	drop := -1
+	if s.drop != nil {
+		drop = s.drop(u, offset)
+	}
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	var body io.Reader = r.Body
+	if drop >= 0 {
+		body = io.LimitReader(r.Body, int64(drop))
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	u.Data = append(u.Data, b...)
+	tooLong := u.Length >= 0 && int64(len(u.Data)) > u.Length
+	newOffset := len(u.Data)
+	s.mu.Unlock()
+
+	if drop >= 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(fmt.Sprintf("tustest: unable to drop connection: %s", err))
+		}
+		conn.Close()
+		return
+	}
+	if tooLong {
+		http.Error(w, "upload is longer than Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.Itoa(newOffset))
+	w.WriteHeader(http.StatusNoContent)
+}
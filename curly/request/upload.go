@@ -7,6 +7,8 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
 )
 
 //type Uploader struct {
@@ -45,42 +47,86 @@ func UploadGZIP(uploadURL string, filename string, r io.Reader) (*http.Request,
 	gzipW.Close()
 	writer.Close()
 
-	return createPostRequest(uploadURL, writer, body)
+	return createPostRequest(uploadURL, writer, body, true)
 }
 
 func UploadGZIPZeroMemory(uploadURL string, filename string, r io.Reader) (*http.Request, error) {
+	return uploadGZIPZeroMemory(uploadURL, fmt.Sprintf("%s.gz", filename), r, nil)
+}
+
+// UploadEncryptedGZIPZeroMemory works as UploadGZIPZeroMemory, compressed
+// content is encrypted by secret s and uploaded as FILENAME.gz.enc.
+// Uploaded file is decrypted by: curly decrypt FILENAME.gz.enc | gunzip
+func UploadEncryptedGZIPZeroMemory(uploadURL string, filename string, r io.Reader, s *encrypt.Secret) (*http.Request, error) {
+	return uploadGZIPZeroMemory(uploadURL, fmt.Sprintf("%s.gz.enc", filename), r, s)
+}
+
+func uploadGZIPZeroMemory(uploadURL string, filename string, r io.Reader, s *encrypt.Secret) (*http.Request, error) {
 	pipeR, pipeW := io.Pipe()
 	writer := multipart.NewWriter(pipeW)
-	gzfilename := fmt.Sprintf("%s.gz", filename)
 
 	go func() {
 		defer pipeW.Close()
 		defer writer.Close()
-		part, err := writer.CreateFormFile("file", gzfilename)
+		part, err := writer.CreateFormFile("file", filename)
 		if err != nil {
 			err = fmt.Errorf("create form file: %w", err)
 			pipeW.CloseWithError(err)
 			return
 		}
-		gzipW := gzip.NewWriter(part)
-		defer gzipW.Close()
-		_, err = io.Copy(gzipW, r)
-		if err != nil {
+		if err := copyGZIP(part, r, s); err != nil {
 			err = fmt.Errorf("UploadGZIPZeroMemory io.copy: %w", err)
 			pipeW.CloseWithError(err)
 		}
 	}()
 
-	return createPostRequest(uploadURL, writer, pipeR)
+	// encrypted content is not gzip for clients, it must be decrypted first
+	return createPostRequest(uploadURL, writer, pipeR, s == nil)
+}
+
+// GZIPReader returns reader of r compressed by gzip, compressed content is
+// encrypted if s is not nil. Close stops compression when the content is not
+// read till the end.
+func GZIPReader(r io.Reader, s *encrypt.Secret) io.ReadCloser {
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		pipeW.CloseWithError(copyGZIP(pipeW, r, s))
+	}()
+	return pipeR
+}
+
+// copyGZIP compresses r into w, compressed content is encrypted if s is not nil.
+func copyGZIP(w io.Writer, r io.Reader, s *encrypt.Secret) error {
+	var ew io.WriteCloser
+	if s != nil {
+		var err error
+		if ew, err = encrypt.NewWriter(w, s, nil); err != nil {
+			return err
+		}
+		w = ew
+	}
+	gzipW := gzip.NewWriter(w)
+	if _, err := io.Copy(gzipW, r); err != nil {
+		return err
+	}
+	if err := gzipW.Close(); err != nil {
+		return err
+	}
+	if ew != nil {
+		return ew.Close()
+	}
+	return nil
 }
 
-func createPostRequest(uploadURL string, w *multipart.Writer, r io.Reader) (*http.Request, error) {
+func createPostRequest(uploadURL string, w *multipart.Writer, r io.Reader, gzipped bool) (*http.Request, error) {
 	req, err := http.NewRequest(http.MethodPost, uploadURL, r)
 	if err != nil {
 		return nil, fmt.Errorf("upload io.copy: %w", err)
 	}
 
-	req.Header.Add("Content-Encoding", "gzip")
+	if gzipped {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
 	req.Header.Add("Content-Type", w.FormDataContentType())
 	return req, nil
 }
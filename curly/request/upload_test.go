@@ -13,6 +13,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/encrypt"
 )
 
 func HttpTestServer(t *testing.T, input string) *httptest.Server {
@@ -108,6 +111,44 @@ func TestUploadGZIPZeroMemory(t *testing.T) {
 	}
 }
 
+func TestUploadEncryptedGZIPZeroMemory(t *testing.T) {
+	input := "hello1\nhello2\nhello3\nhello4\nhello5\nhello6"
+	secret, err := encrypt.NewPassphrase([]byte("secret"))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		file, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer file.Close()
+		assert.Equal(t, "my-name.gz.enc", header.Filename)
+
+		dr, err := encrypt.NewReader(file, secret, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		zr, err := gzip.NewReader(dr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		got, err := io.ReadAll(zr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, input, string(got))
+	}))
+	defer ts.Close()
+
+	req, err := UploadEncryptedGZIPZeroMemory(ts.URL, "my-name", strings.NewReader(input), secret)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 func BenchServer(b *testing.B) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseMultipartForm(1024 * 1024) // limit your max input length!
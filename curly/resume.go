@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// resumeState is stored in a sidecar file next to the output so an interrupted
+// download can continue where it stopped. The partial output itself is the
+// source of truth for the offset, the state only remembers which version of
+// the resource the partial bytes belong to.
+type resumeState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+}
+
+func stateFilename(output string) string {
+	return fmt.Sprintf("%s.curly", output)
+}
+
+func newResumeState(u *url.URL, resp *http.Response) *resumeState {
+	return &resumeState{
+		URL:          u.String(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         resp.ContentLength,
+	}
+}
+
+func loadResumeState(fname string) (*resumeState, error) {
+	b, err := os.ReadFile(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read resume state: %w", err)
+	}
+	var st resumeState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("unable to decode resume state %s: %w", fname, err)
+	}
+	return &st, nil
+}
+
+func (s *resumeState) save(fname string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("unable to encode resume state: %w", err)
+	}
+	if err := os.WriteFile(fname, b, 0o644); err != nil {
+		return fmt.Errorf("unable to write resume state: %w", err)
+	}
+	return nil
+}
+
+// ifRange returns validator for If-Range header. Strong ETag is preferred,
+// weak ETags are not allowed in If-Range so Last-Modified is used instead.
+func (s *resumeState) ifRange() string {
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+// changed returns true when validator of resp sent in If-Range differs from
+// the state, so the whole resource is sent because it has changed.
+func (s *resumeState) changed(resp *http.Response) bool {
+	got := resp.Header.Get("Last-Modified")
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		got = resp.Header.Get("ETag")
+	}
+	return got != "" && got != s.ifRange()
+}
+
+// resumeDownload downloads u into output file. When output contains partial
+// content from previous run and the sidecar state matches u, only the missing
+// bytes are requested. Returned offset is the number of bytes which are already
+// in the output file, caller must not write them again.
+// If server does not honor the range request download starts from the beginning.
+// Requests are sent with header h.
+func resumeDownload(ctx context.Context, log *zap.SugaredLogger, c *http.Client, u *url.URL, h http.Header, output string) (*http.Response, *os.File, int64, error) {
+	stateFile := stateFilename(output)
+	st, err := loadResumeState(stateFile)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var offset int64
+	if fi, err := os.Stat(output); err == nil && st != nil && st.URL == u.String() && st.ifRange() != "" {
+		offset = fi.Size()
+	}
+
+	resp, err := rangeRequest(ctx, c, u, h, offset, st)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if offset > 0 {
+		start, err := contentRangeStart(resp)
+		if err != nil || start != offset {
+			if st.changed(resp) {
+				log.Warnf("%s changed since partial download, downloading it from the beginning", u)
+			} else {
+				log.Warnf("server does not support range requests, downloading %s from the beginning", u)
+			}
+			offset = 0
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				if resp, err = rangeRequest(ctx, c, u, h, 0, nil); err != nil {
+					return nil, nil, 0, err
+				}
+			}
+		}
+	}
+
+	f, err := os.OpenFile(output, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, 0, fmt.Errorf("unable to open output file: %w", err)
+	}
+	// drop anything written after offset (or whole file when restarting)
+	if err := f.Truncate(offset); err != nil {
+		resp.Body.Close()
+		f.Close()
+		return nil, nil, 0, fmt.Errorf("unable to truncate output file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		resp.Body.Close()
+		f.Close()
+		return nil, nil, 0, fmt.Errorf("unable to seek output file: %w", err)
+	}
+
+	if offset == 0 {
+		if err := newResumeState(u, resp).save(stateFile); err != nil {
+			resp.Body.Close()
+			f.Close()
+			return nil, nil, 0, err
+		}
+	}
+
+	return resp, f, offset, nil
+}
+
+func rangeRequest(ctx context.Context, c *http.Client, u *url.URL, h http.Header, offset int64, st *resumeState) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setHeader(req, h)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", st.ifRange())
+	}
+	return c.Do(req)
+}
+
+// contentRangeStart returns first byte position from Content-Range header
+// of 206 Partial Content response, e.g. "bytes 100-199/200" returns 100.
+func contentRangeStart(resp *http.Response) (int64, error) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("response is not http.StatusPartialContent, got %s", resp.Status)
+	}
+	cr := resp.Header.Get("Content-Range")
+	if !strings.HasPrefix(cr, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range: %q", cr)
+	}
+	i := strings.IndexByte(cr, '-')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range: %q", cr)
+	}
+	start, err := strconv.ParseInt(cr[len("bytes "):i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q: %w", cr, err)
+	}
+	return start, nil
+}
+
+// skipWriter discards first n bytes and writes the rest into w. It is used for
+// output of resumed download because the whole stream is replayed (hashes and
+// chunks are computed from all bytes) but the prefix is already on disk.
+type skipWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	l := len(p)
+	if s.n >= int64(l) {
+		s.n -= int64(l)
+		return l, nil
+	}
+	p = p[s.n:]
+	s.n = 0
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return l, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const resumeContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func TestResumeDownload(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		partial    string
+		state      *resumeState
+		wantOffset int64
+		wantWarn   string
+	}{
+		{
+			name: "resume with etag",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(resumeContent))
+			},
+			partial:    resumeContent[:10],
+			state:      &resumeState{ETag: `"v1"`},
+			wantOffset: 10,
+		},
+		{
+			name: "etag changed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v2"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(resumeContent))
+			},
+			partial:    "garbage",
+			state:      &resumeState{ETag: `"v1"`},
+			wantOffset: 0,
+			wantWarn:   "changed since partial download",
+		},
+		{
+			name: "last modified changed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), strings.NewReader(resumeContent))
+			},
+			partial:    resumeContent[:10],
+			state:      &resumeState{LastModified: "Fri, 01 Jan 2021 00:00:00 GMT"},
+			wantOffset: 0,
+			wantWarn:   "changed since partial download",
+		},
+		{
+			name: "range not supported",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, resumeContent)
+			},
+			partial:    resumeContent[:10],
+			state:      &resumeState{ETag: `"v1"`},
+			wantOffset: 0,
+			wantWarn:   "server does not support range requests",
+		},
+		{
+			name: "no state",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(resumeContent))
+			},
+			partial:    resumeContent[:10],
+			wantOffset: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			require.NoError(t, err)
+
+			output := filepath.Join(t.TempDir(), "out")
+			require.NoError(t, os.WriteFile(output, []byte(tt.partial), 0o644))
+			if tt.state != nil {
+				tt.state.URL = u.String()
+				require.NoError(t, tt.state.save(stateFilename(output)))
+			}
+
+			core, logs := observer.New(zap.WarnLevel)
+			resp, f, offset, err := resumeDownload(context.Background(), zap.New(core).Sugar(), ts.Client(), u, nil, output)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			defer f.Close()
+			assert.Equal(t, tt.wantOffset, offset)
+			if tt.wantWarn == "" {
+				assert.Zero(t, logs.Len())
+			} else {
+				require.Equal(t, 1, logs.Len())
+				assert.Contains(t, logs.All()[0].Message, tt.wantWarn)
+			}
+
+			full := io.MultiReader(io.NewSectionReader(f, 0, offset), resp.Body)
+			var got bytes.Buffer
+			_, err = io.Copy(io.MultiWriter(&got, &skipWriter{w: f, n: offset}), full)
+			require.NoError(t, err)
+			assert.Equal(t, resumeContent, got.String())
+
+			b, err := os.ReadFile(output)
+			require.NoError(t, err)
+			assert.Equal(t, resumeContent, string(b))
+
+			st, err := loadResumeState(stateFilename(output))
+			require.NoError(t, err)
+			assert.Equal(t, u.String(), st.URL)
+		})
+	}
+}
+
+func TestSkipWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &skipWriter{w: &buf, n: 5}
+	for _, p := range []string{"012", "345", "6789"} {
+		n, err := w.Write([]byte(p))
+		assert.NoError(t, err)
+		assert.Equal(t, len(p), n)
+	}
+	assert.Equal(t, "56789", buf.String())
+}
@@ -0,0 +1,659 @@
+package roundtripper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CacheHeader of responses returned by Cache is HIT for fresh stored
+	// response, REVALIDATED for stored response confirmed by 304 Not Modified
+	// and MISS for response from network.
+	CacheHeader = "X-Cache"
+
+	cacheMetaExt = ".json"
+	cacheBodyExt = ".body"
+
+	// maxDeltaSeconds caps max-age, RFC 9111 1.2.2.
+	maxDeltaSeconds = 1<<31 - 1
+)
+
+// CacheOptions configures NewCache.
+type CacheOptions struct {
+	// MaxSize limits total size of stored bodies, least recently used
+	// responses are evicted. Zero means no limit.
+	MaxSize int64
+	// Bypass does not use stored responses, but responses from network are
+	// stored.
+	Bypass bool
+	// Logger logs failures of cache directory, it is optional.
+	Logger Logger
+}
+
+// Cache is private HTTP cache RoundTripper which stores responses of GET
+// requests in directory, see RFC 9111. Fresh responses are served from disk,
+// stale ones are revalidated by If-None-Match and If-Modified-Since. Range and
+// conditional requests of client are not cached.
+type Cache struct {
+	rt   http.RoundTripper
+	dir  string
+	opts CacheOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry
+	size    int64
+}
+
+// cacheEntry is metadata of stored response, it is kept in dir/key.json next
+// to body in dir/key.body.
+type cacheEntry struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+	// Vary are request headers named by Vary response header.
+	Vary         map[string]string `json:"vary,omitempty"`
+	Status       string            `json:"status"`
+	StatusCode   int               `json:"statusCode"`
+	Proto        string            `json:"proto"`
+	Header       http.Header       `json:"header"`
+	Size         int64             `json:"size"`
+	RequestTime  time.Time         `json:"requestTime"`
+	ResponseTime time.Time         `json:"responseTime"`
+
+	// used is modification time of body file, it is updated by every hit.
+	used time.Time
+}
+
+// NewCache returns Cache of responses of rt stored in dir, the directory is
+// created when it does not exist.
+func NewCache(rt http.RoundTripper, dir string, opts CacheOptions) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	c := &Cache{
+		rt:      rt,
+		dir:     dir,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string][]*cacheEntry),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// load reads metadata of stored responses, incomplete entries are removed.
+func (c *Cache) load() error {
+	names, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheMetaExt))
+	if err != nil {
+		return fmt.Errorf("unable to list cache directory: %w", err)
+	}
+	for _, name := range names {
+		key := strings.TrimSuffix(filepath.Base(name), cacheMetaExt)
+		e, err := c.loadEntry(key)
+		if err != nil {
+			c.logf("removing invalid cache entry %s: %s", key, err)
+			c.remove(key)
+			continue
+		}
+		c.add(e)
+	}
+	return nil
+}
+
+func (c *Cache) loadEntry(key string) (*cacheEntry, error) {
+	b, err := os.ReadFile(filepath.Join(c.dir, key+cacheMetaExt))
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(c.dir, key+cacheBodyExt))
+	if err != nil {
+		return nil, err
+	}
+	if e.Key != key || fi.Size() != e.Size {
+		return nil, errors.New("metadata does not match body")
+	}
+	e.used = fi.ModTime()
+	return &e, nil
+}
+
+// Purge removes all stored responses.
+func (c *Cache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names, err := filepath.Glob(filepath.Join(c.dir, "*"))
+	if err != nil {
+		return fmt.Errorf("unable to list cache directory: %w", err)
+	}
+	for _, name := range names {
+		if ext := filepath.Ext(name); ext != cacheMetaExt && ext != cacheBodyExt && ext != ".tmp" {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("unable to purge cache: %w", err)
+		}
+	}
+	c.entries = make(map[string][]*cacheEntry)
+	c.size = 0
+	return nil
+}
+
+func (c *Cache) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqCC := parseCacheControl(r.Header)
+	if !cacheable(r) || reqCC.has("no-store") {
+		return c.rt.RoundTrip(r)
+	}
+
+	var e *cacheEntry
+	if !c.opts.Bypass {
+		e = c.lookup(r)
+	}
+	if e != nil && !reqCC.has("no-cache") && c.fresh(e, reqCC) {
+		resp, err := c.serve(r, e, "HIT")
+		if err == nil {
+			return resp, nil
+		}
+		c.logf("%s, sending request", err)
+		e = nil
+	}
+
+	req := r
+	if e != nil {
+		req = r.Clone(r.Context())
+		if etag := e.Header.Get("Etag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lm := e.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+	requestTime := c.now()
+	resp, err := c.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := c.now()
+
+	if e != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		e = c.refresh(e, resp.Header, requestTime, responseTime)
+		return c.serve(r, e, "REVALIDATED")
+	}
+	if e != nil {
+		// stored response is replaced by new one or it is not valid anymore
+		c.invalidate(e)
+	}
+
+	resp.Header.Set(CacheHeader, "MISS")
+	if vary, ok := storable(r, resp); ok {
+		e := &cacheEntry{
+			URL:          r.URL.String(),
+			Vary:         vary,
+			Status:       resp.Status,
+			StatusCode:   resp.StatusCode,
+			Proto:        resp.Proto,
+			Header:       resp.Header.Clone(),
+			RequestTime:  requestTime,
+			ResponseTime: responseTime,
+		}
+		e.Header.Del(CacheHeader)
+		e.Key = cacheKey(e.URL, vary)
+		body, err := c.newCacheBody(resp.Body, e)
+		if err != nil {
+			c.logf("unable to store %s: %s", e.URL, err)
+			return resp, nil
+		}
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// cacheable returns true for GET request which does not depend on state of
+// client.
+func cacheable(r *http.Request) bool {
+	if r.Method != "" && r.Method != http.MethodGet {
+		return false
+	}
+	for _, h := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if r.Header.Get(h) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// heuristicStatus are status codes cacheable by default, RFC 9110 15.1.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// storable returns request headers named by Vary when response can be stored.
+// Response which has neither freshness nor validator is not stored as it can
+// not be used.
+func storable(r *http.Request, resp *http.Response) (map[string]string, bool) {
+	cc := parseCacheControl(resp.Header)
+	if !heuristicStatus[resp.StatusCode] || cc.has("no-store") {
+		return nil, false
+	}
+	if !cc.has("max-age") && resp.Header.Get("Expires") == "" &&
+		resp.Header.Get("Etag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return nil, false
+	}
+	var vary map[string]string
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = strings.Join(r.Header.Values(name), ",")
+		}
+	}
+	return vary, true
+}
+
+// cacheKey is file name of stored response, it is hash of URL and values of
+// Vary headers.
+func cacheKey(u string, vary map[string]string) string {
+	names := make([]string, 0, len(vary))
+	for name := range vary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	io.WriteString(h, u)
+	for _, name := range names {
+		fmt.Fprintf(h, "\n%s: %s", name, vary[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns stored response matching URL and Vary headers of r.
+func (c *Cache) lookup(r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries[r.URL.String()] {
+		if e.matches(r) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, v := range e.Vary {
+		if strings.Join(r.Header.Values(name), ",") != v {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh returns true when age of stored response is within its freshness
+// lifetime and max-age of request.
+func (c *Cache) fresh(e *cacheEntry, reqCC cacheControl) bool {
+	cc := parseCacheControl(e.Header)
+	if cc.has("no-cache") {
+		return false
+	}
+	age := e.age(c.now())
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	return e.lifetime(cc) > age
+}
+
+// lifetime is freshness lifetime of response, RFC 9111 4.2.1. Responses
+// without explicit expiration are fresh for 10% of time since Last-Modified.
+func (e *cacheEntry) lifetime(cc cacheControl) time.Duration {
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	date := e.date()
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid date means already expired
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(lm) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// age is current age of response, RFC 9111 4.2.3.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparent := e.ResponseTime.Sub(e.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	var ageValue time.Duration
+	if s, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && s > 0 {
+		ageValue = time.Duration(s) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if corrected < apparent {
+		corrected = apparent
+	}
+	return corrected + now.Sub(e.ResponseTime)
+}
+
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// serve returns stored response with body read from disk.
+func (c *Cache) serve(r *http.Request, e *cacheEntry, status string) (*http.Response, error) {
+	f, err := os.Open(filepath.Join(c.dir, e.Key+cacheBodyExt))
+	if err != nil {
+		c.mu.Lock()
+		c.drop(e)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("unable to open cached response: %w", err)
+	}
+	c.touch(e)
+
+	major, minor, ok := http.ParseHTTPVersion(e.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.age(c.now())/time.Second), 10))
+	header.Set(CacheHeader, status)
+	return &http.Response{
+		Status:        e.Status,
+		StatusCode:    e.StatusCode,
+		Proto:         e.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          f,
+		ContentLength: e.Size,
+		Request:       r,
+	}, nil
+}
+
+// touch marks e as recently used.
+func (c *Cache) touch(e *cacheEntry) {
+	now := c.now()
+	if err := os.Chtimes(filepath.Join(c.dir, e.Key+cacheBodyExt), now, now); err != nil {
+		c.logf("unable to touch cached response %s: %s", e.URL, err)
+	}
+	c.mu.Lock()
+	e.used = now
+	c.mu.Unlock()
+}
+
+// refresh updates stored headers by headers of 304 Not Modified response,
+// RFC 9111 4.3.4. Entries are not modified as they are read concurrently,
+// updated copy replaces e.
+func (c *Cache) refresh(e *cacheEntry, h http.Header, requestTime, responseTime time.Time) *cacheEntry {
+	updated := *e
+	updated.Header = e.Header.Clone()
+	for name, values := range h {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", CacheHeader:
+			continue
+		}
+		updated.Header[name] = values
+	}
+	updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeMeta(&updated); err != nil {
+		c.logf("unable to update cached response %s: %s", e.URL, err)
+	}
+	c.drop(e)
+	c.add(&updated)
+	return &updated
+}
+
+// invalidate removes stored response e.
+func (c *Cache) invalidate(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop(e)
+	c.remove(e.Key)
+}
+
+func (c *Cache) writeMeta(e *cacheEntry) error {
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, e.Key+cacheMetaExt), b)
+}
+
+func writeFileAtomic(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// store commits body written into temporary file tmp and its metadata.
+func (c *Cache) store(e *cacheEntry, tmp string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(c.dir, e.Key+cacheBodyExt)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := c.writeMeta(e); err != nil {
+		c.remove(e.Key)
+		return err
+	}
+	e.used = c.now()
+	if err := os.Chtimes(filepath.Join(c.dir, e.Key+cacheBodyExt), e.used, e.used); err != nil {
+		c.logf("unable to touch cached response %s: %s", e.URL, err)
+	}
+	for _, old := range c.entries[e.URL] {
+		if old.Key == e.Key {
+			c.drop(old)
+			break
+		}
+	}
+	c.add(e)
+	c.evict()
+	return nil
+}
+
+func (c *Cache) add(e *cacheEntry) {
+	c.entries[e.URL] = append(c.entries[e.URL], e)
+	c.size += e.Size
+}
+
+// drop removes e from index, files are left on disk.
+func (c *Cache) drop(e *cacheEntry) {
+	entries := c.entries[e.URL]
+	for i, old := range entries {
+		if old == e {
+			c.entries[e.URL] = append(entries[:i:i], entries[i+1:]...)
+			c.size -= e.Size
+			break
+		}
+	}
+	if len(c.entries[e.URL]) == 0 {
+		delete(c.entries, e.URL)
+	}
+}
+
+func (c *Cache) remove(key string) {
+	os.Remove(filepath.Join(c.dir, key+cacheMetaExt))
+	os.Remove(filepath.Join(c.dir, key+cacheBodyExt))
+}
+
+// evict removes least recently used responses until size of bodies is within
+// MaxSize.
+func (c *Cache) evict() {
+	if c.opts.MaxSize <= 0 || c.size <= c.opts.MaxSize {
+		return
+	}
+	var all []*cacheEntry
+	for _, entries := range c.entries {
+		all = append(all, entries...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].used.Before(all[j].used) })
+	for _, e := range all {
+		if c.size <= c.opts.MaxSize {
+			break
+		}
+		c.logf("evicting cached response %s of %d bytes", e.URL, e.Size)
+		c.drop(e)
+		c.remove(e.Key)
+	}
+}
+
+func (c *Cache) logf(format string, args ...interface{}) {
+	if c.opts.Logger != nil {
+		c.opts.Logger.Debugf(format, args...)
+	}
+}
+
+// cacheBody copies response body into temporary file, the response is stored
+// when the whole body is read.
+type cacheBody struct {
+	rc    io.ReadCloser
+	c     *Cache
+	e     *cacheEntry
+	f     *os.File
+	n     int64
+	ended bool
+}
+
+func (c *Cache) newCacheBody(rc io.ReadCloser, e *cacheEntry) (*cacheBody, error) {
+	f, err := os.CreateTemp(c.dir, e.Key+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &cacheBody{rc: rc, c: c, e: e, f: f}, nil
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if b.f != nil && n > 0 {
+		if _, werr := b.f.Write(p[:n]); werr != nil {
+			b.c.logf("unable to store %s: %s", b.e.URL, werr)
+			b.abort()
+		}
+		b.n += int64(n)
+	}
+	if err == io.EOF && b.f != nil {
+		b.commit()
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	// partially read body is not stored
+	b.abort()
+	return b.rc.Close()
+}
+
+func (b *cacheBody) commit() {
+	f := b.f
+	b.f = nil
+	if err := f.Close(); err != nil {
+		b.c.logf("unable to store %s: %s", b.e.URL, err)
+		os.Remove(f.Name())
+		return
+	}
+	b.e.Size = b.n
+	if err := b.c.store(b.e, f.Name()); err != nil {
+		b.c.logf("unable to store %s: %s", b.e.URL, err)
+	}
+}
+
+func (b *cacheBody) abort() {
+	if b.f == nil {
+		return
+	}
+	b.f.Close()
+	os.Remove(b.f.Name())
+	b.f = nil
+}
+
+// cacheControl are directives of Cache-Control header, names are lower case.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				name, value = d[:i], strings.Trim(strings.TrimSpace(d[i+1:]), `"`)
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns delta-seconds value of directive, invalid value is zero.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	s, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || s < 0 {
+		return 0, true
+	}
+	if s > maxDeltaSeconds {
+		s = maxDeltaSeconds
+	}
+	return time.Duration(s) * time.Second, true
+}
@@ -0,0 +1,278 @@
+package roundtripper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is shared by cache and server which sends it in Date header.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// cacheServer serves content of path, it counts requests and supports
+// conditional requests by ETag of content.
+type cacheServer struct {
+	*httptest.Server
+	clock *fakeClock
+
+	mu       sync.Mutex
+	content  map[string]string
+	header   map[string]http.Header
+	requests map[string]int
+}
+
+func newCacheServer(t *testing.T) *cacheServer {
+	s := &cacheServer{
+		clock:    &fakeClock{t: time.Now()},
+		content:  make(map[string]string),
+		header:   make(map[string]http.Header),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests[r.URL.Path]++
+		w.Header().Set("Date", s.clock.Now().UTC().Format(http.TimeFormat))
+		for name, values := range s.header[r.URL.Path] {
+			w.Header()[name] = values
+		}
+		content := s.content[r.URL.Path]
+		if r.URL.Path == "/vary" {
+			content += " " + r.Header.Get("Accept-Language")
+		}
+		etag := `"` + content + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *cacheServer) set(path, content string, header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content[path] = content
+	s.header[path] = header
+}
+
+func (s *cacheServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+type cacheClient struct {
+	t     *testing.T
+	c     *http.Client
+	cache *Cache
+}
+
+func newCacheClient(t *testing.T, s *cacheServer, dir string, opts CacheOptions) *cacheClient {
+	cache, err := NewCache(http.DefaultTransport, dir, opts)
+	require.NoError(t, err)
+	cache.now = s.clock.Now
+	return &cacheClient{t: t, c: &http.Client{Transport: cache}, cache: cache}
+}
+
+// get returns body and X-Cache header of response.
+func (cc *cacheClient) get(u string, header ...string) (string, string) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	require.NoError(cc.t, err)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := cc.c.Do(req)
+	require.NoError(cc.t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(cc.t, err)
+	return string(b), resp.Header.Get(CacheHeader)
+}
+
+func TestCache_Freshness(t *testing.T) {
+	s := newCacheServer(t)
+	s.set("/max-age", "max-age", http.Header{"Cache-Control": {"max-age=60"}})
+	s.set("/no-cache", "no-cache", http.Header{"Cache-Control": {"no-cache"}})
+	s.set("/no-store", "no-store", http.Header{"Cache-Control": {"no-store"}})
+	s.set("/last-modified", "last-modified", http.Header{"Last-Modified": {s.clock.Now().Add(-100 * time.Minute).UTC().Format(http.TimeFormat)}})
+	cc := newCacheClient(t, s, t.TempDir(), CacheOptions{})
+
+	tests := []struct {
+		path  string
+		after time.Duration
+		want  string
+	}{
+		{path: "/max-age", want: "MISS"},
+		{path: "/max-age", after: 30 * time.Second, want: "HIT"},
+		{path: "/max-age", after: 31 * time.Second, want: "REVALIDATED"},
+		{path: "/max-age", want: "HIT"},
+		{path: "/no-cache", want: "MISS"},
+		{path: "/no-cache", want: "REVALIDATED"},
+		{path: "/no-store", want: "MISS"},
+		{path: "/no-store", want: "MISS"},
+		// heuristic freshness is 10% of time since Last-Modified
+		{path: "/last-modified", want: "MISS"},
+		{path: "/last-modified", after: 9 * time.Minute, want: "HIT"},
+		{path: "/last-modified", after: 2 * time.Minute, want: "REVALIDATED"},
+	}
+	for i, tt := range tests {
+		s.clock.Add(tt.after)
+		body, got := cc.get(s.URL + tt.path)
+		assert.Equal(t, tt.path[1:], body, "%d %s", i, tt.path)
+		assert.Equal(t, tt.want, got, "%d %s", i, tt.path)
+	}
+	assert.Equal(t, 2, s.count("/max-age"))
+	assert.Equal(t, 2, s.count("/no-cache"))
+	assert.Equal(t, 2, s.count("/no-store"))
+
+	// request directives
+	body, got := cc.get(s.URL+"/max-age", "Cache-Control", "no-cache")
+	assert.Equal(t, "max-age", body)
+	assert.Equal(t, "REVALIDATED", got)
+	s.clock.Add(10 * time.Second)
+	_, got = cc.get(s.URL+"/max-age", "Cache-Control", "max-age=5")
+	assert.Equal(t, "REVALIDATED", got)
+	_, got = cc.get(s.URL+"/max-age", "Range", "bytes=0-1")
+	assert.Equal(t, "", got)
+}
+
+func TestCache_Revalidate_Changed(t *testing.T) {
+	s := newCacheServer(t)
+	s.set("/", "old", nil)
+	dir := t.TempDir()
+	cc := newCacheClient(t, s, dir, CacheOptions{})
+
+	body, got := cc.get(s.URL)
+	assert.Equal(t, "old", body)
+	assert.Equal(t, "MISS", got)
+
+	s.set("/", "new", nil)
+	body, got = cc.get(s.URL)
+	assert.Equal(t, "new", body)
+	assert.Equal(t, "MISS", got)
+
+	// new cache loads stored responses from dir
+	cc = newCacheClient(t, s, dir, CacheOptions{})
+	body, got = cc.get(s.URL)
+	assert.Equal(t, "new", body)
+	assert.Equal(t, "REVALIDATED", got)
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, names, 2, "replaced response is removed")
+}
+
+func TestCache_Vary(t *testing.T) {
+	s := newCacheServer(t)
+	s.set("/vary", "hello", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}})
+	cc := newCacheClient(t, s, t.TempDir(), CacheOptions{})
+
+	for _, tt := range []struct{ lang, want string }{
+		{lang: "en", want: "MISS"},
+		{lang: "cs", want: "MISS"},
+		{lang: "en", want: "HIT"},
+		{lang: "cs", want: "HIT"},
+	} {
+		body, got := cc.get(s.URL+"/vary", "Accept-Language", tt.lang)
+		assert.Equal(t, "hello "+tt.lang, body)
+		assert.Equal(t, tt.want, got, tt.lang)
+	}
+	assert.Equal(t, 2, s.count("/vary"))
+}
+
+func TestCache_BypassPurge(t *testing.T) {
+	s := newCacheServer(t)
+	s.set("/", "content", http.Header{"Cache-Control": {"max-age=60"}})
+	dir := t.TempDir()
+
+	bypass := newCacheClient(t, s, dir, CacheOptions{Bypass: true})
+	_, got := bypass.get(s.URL)
+	assert.Equal(t, "MISS", got)
+	_, got = bypass.get(s.URL)
+	assert.Equal(t, "MISS", got)
+
+	cc := newCacheClient(t, s, dir, CacheOptions{})
+	_, got = cc.get(s.URL)
+	assert.Equal(t, "HIT", got, "response is stored by bypass")
+
+	require.NoError(t, cc.cache.Purge())
+	_, got = cc.get(s.URL)
+	assert.Equal(t, "MISS", got)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), nil, 0o644))
+	require.NoError(t, cc.cache.Purge())
+	assert.FileExists(t, filepath.Join(dir, "README"), "purge removes only cache files")
+}
+
+func TestCache_Evict(t *testing.T) {
+	s := newCacheServer(t)
+	for _, p := range []string{"/a", "/b", "/c"} {
+		s.set(p, strings.Repeat(p[1:], 5), http.Header{"Cache-Control": {"max-age=60"}})
+	}
+	dir := t.TempDir()
+	cc := newCacheClient(t, s, dir, CacheOptions{MaxSize: 10})
+	step := func(p, want string) {
+		s.clock.Add(time.Second)
+		_, got := cc.get(s.URL + p)
+		assert.Equal(t, want, got, p)
+	}
+
+	step("/a", "MISS")
+	step("/b", "MISS")
+	step("/a", "HIT")
+	// /b is least recently used
+	step("/c", "MISS")
+	step("/c", "HIT")
+	step("/a", "HIT")
+	// /c is least recently used
+	step("/b", "MISS")
+
+	// size limit is applied to loaded entries, /a is least recently used
+	cc = newCacheClient(t, s, dir, CacheOptions{MaxSize: 5})
+	step("/b", "HIT")
+	step("/a", "MISS")
+}
+
+func TestCache_PartialBody(t *testing.T) {
+	s := newCacheServer(t)
+	s.set("/", strings.Repeat("x", 1000), http.Header{"Cache-Control": {"max-age=60"}})
+	cc := newCacheClient(t, s, t.TempDir(), CacheOptions{})
+
+	resp, err := cc.c.Get(s.URL)
+	require.NoError(t, err)
+	_, err = io.CopyN(io.Discard, resp.Body, 10)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, got := cc.get(s.URL)
+	assert.Equal(t, "MISS", got)
+	_, got = cc.get(s.URL)
+	assert.Equal(t, "HIT", got)
+}
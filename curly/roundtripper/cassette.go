@@ -0,0 +1,332 @@
+package roundtripper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNotRecorded is returned by Replayer for request which is not in the
+// cassette.
+var ErrNotRecorded = errors.New("request is not recorded in cassette")
+
+// cassette is JSON file with recorded exchanges in order they were sent.
+type cassette struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request  cassetteRequest  `json:"request"`
+	Response cassetteResponse `json:"response"`
+}
+
+type cassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	cassetteBody
+}
+
+type cassetteResponse struct {
+	Status        string      `json:"status"`
+	StatusCode    int         `json:"statusCode"`
+	Proto         string      `json:"proto"`
+	Header        http.Header `json:"header,omitempty"`
+	ContentLength int64       `json:"contentLength"`
+	cassetteBody
+}
+
+// cassetteBody is kept as text so cassettes can be reviewed and edited,
+// binary body is base64 encoded.
+type cassetteBody struct {
+	Body     string `json:"body,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// Truncated is true when body was larger than Recorder.MaxBodySize, it
+	// is not recorded.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func newCassetteBody(b []byte) cassetteBody {
+	if utf8.Valid(b) {
+		return cassetteBody{Body: string(b)}
+	}
+	return cassetteBody{Body: base64.StdEncoding.EncodeToString(b), Encoding: "base64"}
+}
+
+func (b cassetteBody) bytes() ([]byte, error) {
+	switch b.Encoding {
+	case "":
+		return []byte(b.Body), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(b.Body)
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", b.Encoding)
+	}
+}
+
+// defaultRecordBodySize is the most bytes of one body kept by Recorder.
+const defaultRecordBodySize = 64 << 20
+
+// Recorder is RoundTripper which records requests and responses including
+// bodies into cassette file, which is played back by Replayer. Bodies are
+// recorded as they are read by the client and kept in memory until the
+// cassette is saved. Credential headers are Redacted unless KeepCredentials
+// is set, so cassettes can be committed as test fixtures.
+type Recorder struct {
+	// MaxBodySize is the most bytes of request or response body kept in
+	// memory. Larger body is recorded as truncated and its replay fails.
+	MaxBodySize int64
+	// KeepCredentials records Authorization, Cookie, Set-Cookie and other
+	// credential headers verbatim.
+	KeepCredentials bool
+
+	rt   http.RoundTripper
+	path string
+
+	mu         sync.Mutex
+	recordings []*recording
+}
+
+// recording is interaction whose bodies are being read.
+type recording struct {
+	interaction
+	reqBody  *recordBody
+	respBody *recordBody
+}
+
+// NewRecorder returns Recorder of requests sent by rt, Save writes them into
+// cassette file path.
+func NewRecorder(rt http.RoundTripper, path string) *Recorder {
+	return &Recorder{MaxBodySize: defaultRecordBodySize, rt: rt, path: path}
+}
+
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	i := &recording{
+		interaction: interaction{
+			Request: cassetteRequest{
+				Method: r.Method,
+				URL:    r.URL.String(),
+				Header: rec.header(r.Header),
+			},
+		},
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		i.reqBody = &recordBody{rc: r.Body, limit: rec.MaxBodySize}
+		r = r.Clone(r.Context())
+		r.Body = i.reqBody
+	}
+
+	resp, err := rec.rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	i.Response = cassetteResponse{
+		Status:        resp.Status,
+		StatusCode:    resp.StatusCode,
+		Proto:         resp.Proto,
+		Header:        rec.header(resp.Header),
+		ContentLength: resp.ContentLength,
+	}
+	i.respBody = &recordBody{rc: resp.Body, limit: rec.MaxBodySize}
+	resp.Body = i.respBody
+
+	rec.mu.Lock()
+	rec.recordings = append(rec.recordings, i)
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+// header returns copy of h with redacted credentials unless they are kept.
+func (rec *Recorder) header(h http.Header) http.Header {
+	if rec.KeepCredentials {
+		return h.Clone()
+	}
+	return redactHeader(h)
+}
+
+// Save writes recorded interactions into the cassette file, the file is
+// replaced atomically.
+func (rec *Recorder) Save() error {
+	rec.mu.Lock()
+	c := cassette{Interactions: make([]*interaction, 0, len(rec.recordings))}
+	for _, r := range rec.recordings {
+		i := r.interaction
+		i.Request.cassetteBody = r.reqBody.cassetteBody()
+		i.Response.cassetteBody = r.respBody.cassetteBody()
+		c.Interactions = append(c.Interactions, &i)
+	}
+	rec.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("unable to encode cassette: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(rec.path), filepath.Base(rec.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create cassette: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write cassette: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write cassette: %w", err)
+	}
+	return os.Rename(f.Name(), rec.path)
+}
+
+// recordBody keeps bytes of body as they are read, up to limit. Body which is
+// closed before it is read completely is recorded as far as it was read.
+type recordBody struct {
+	rc    io.ReadCloser
+	limit int64
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.mu.Lock()
+	if !b.truncated {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.truncated = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *recordBody) Close() error {
+	return b.rc.Close()
+}
+
+func (b *recordBody) cassetteBody() cassetteBody {
+	if b == nil {
+		return cassetteBody{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return cassetteBody{Truncated: true}
+	}
+	return newCassetteBody(b.buf.Bytes())
+}
+
+// Replayer is RoundTripper which answers requests by responses recorded in
+// cassette, it never accesses network.
+type Replayer struct {
+	headers []string
+
+	mu           sync.Mutex
+	interactions []*interaction
+	used         map[*interaction]bool
+}
+
+// NewReplayer loads cassette file path recorded by Recorder. Requests are
+// matched by method, URL and values of headers. Matching interactions are
+// replayed in recorded order, the last one is repeated when all of them were
+// replayed.
+func NewReplayer(path string, headers ...string) (*Replayer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cassette: %w", err)
+	}
+	var c cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse cassette %s: %w", path, err)
+	}
+	return &Replayer{
+		headers:      headers,
+		interactions: c.Interactions,
+		used:         make(map[*interaction]bool),
+	}, nil
+}
+
+func (rp *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		// request is consumed as by network transport, e.g. upload progress
+		// and rate limit see the whole body
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}
+	i := rp.match(r)
+	if i == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, r.Method, r.URL)
+	}
+	if i.Response.Truncated {
+		return nil, fmt.Errorf("response body of %s %s is larger than recording limit and it is not recorded", r.Method, r.URL)
+	}
+	b, err := i.Response.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode response body of %s %s: %w", r.Method, r.URL, err)
+	}
+	major, minor, ok := http.ParseHTTPVersion(i.Response.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	return &http.Response{
+		Status:        i.Response.Status,
+		StatusCode:    i.Response.StatusCode,
+		Proto:         i.Response.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        i.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: i.Response.ContentLength,
+		Request:       r,
+	}, nil
+}
+
+// match returns the first not replayed interaction matching r, or the last
+// matching one.
+func (rp *Replayer) match(r *http.Request) *interaction {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	var last *interaction
+	for _, i := range rp.interactions {
+		if !rp.matches(i, r) {
+			continue
+		}
+		if !rp.used[i] {
+			rp.used[i] = true
+			return i
+		}
+		last = i
+	}
+	return last
+}
+
+// matches compares method, URL and selected headers, redacted credential
+// header matches any value of the header.
+func (rp *Replayer) matches(i *interaction, r *http.Request) bool {
+	if i.Request.Method != r.Method || i.Request.URL != r.URL.String() {
+		return false
+	}
+	redacted := redactHeader(r.Header)
+	for _, name := range rp.headers {
+		want := strings.Join(i.Request.Header.Values(name), ",")
+		if want != strings.Join(r.Header.Values(name), ",") && want != strings.Join(redacted.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
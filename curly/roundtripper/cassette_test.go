@@ -0,0 +1,255 @@
+package roundtripper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_Replayer(t *testing.T) {
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch {
+		case r.Method == http.MethodPost:
+			b, _ := io.ReadAll(r.Body)
+			io.WriteString(w, "posted "+string(b))
+		case r.Header.Get("Range") != "":
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, "range "+r.Header.Get("Range"))
+		case r.URL.Path == "/binary":
+			w.Write([]byte{0xff, 0x00, 0xfe})
+		default:
+			w.Header().Set("X-Hit", strings.Repeat("x", hits))
+			io.WriteString(w, "hello")
+		}
+	}))
+	defer ts.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(http.DefaultTransport, cassette)
+	c := &http.Client{Transport: rec}
+
+	get := func(c *http.Client, path, rng string) (*http.Response, string, error) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(b), nil
+	}
+
+	_, body, err := get(c, "/", "")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", body)
+	_, _, err = get(c, "/", "")
+	require.NoError(t, err)
+	_, body, err = get(c, "/", "bytes=0-1")
+	require.NoError(t, err)
+	assert.Equal(t, "range bytes=0-1", body)
+	_, _, err = get(c, "/binary", "")
+	require.NoError(t, err)
+	resp, err := c.Post(ts.URL+"/upload", "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "posted data", string(b))
+	require.NoError(t, rec.Save())
+
+	ts.Close()
+	rp, err := NewReplayer(cassette, "Range")
+	require.NoError(t, err)
+	c = &http.Client{Transport: rp}
+
+	resp, body, err = get(c, "/", "")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "x", resp.Header.Get("X-Hit"))
+	assert.Equal(t, int64(5), resp.ContentLength)
+	// interactions are replayed in order, the last one is repeated
+	for _, want := range []string{"xx", "xx"} {
+		resp, _, err = get(c, "/", "")
+		require.NoError(t, err)
+		assert.Equal(t, want, resp.Header.Get("X-Hit"))
+	}
+
+	resp, body, err = get(c, "/", "bytes=0-1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "range bytes=0-1", body)
+
+	_, body, err = get(c, "/binary", "")
+	require.NoError(t, err)
+	assert.Equal(t, "\xff\x00\xfe", body)
+
+	resp, err = c.Post(ts.URL+"/upload", "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	b, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "posted data", string(b))
+
+	_, _, err = get(c, "/", "bytes=2-3")
+	assert.True(t, errors.Is(err, ErrNotRecorded), err)
+	_, _, err = get(c, "/missing", "")
+	assert.True(t, errors.Is(err, ErrNotRecorded), err)
+}
+
+func TestNewReplayer_Invalid(t *testing.T) {
+	_, err := NewReplayer(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestRecorder_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			io.WriteString(w, "first ")
+			w.(http.Flusher).Flush()
+			<-release
+			io.WriteString(w, "second")
+		case "/large":
+			io.WriteString(w, "0123456789")
+		default:
+			io.WriteString(w, "small")
+		}
+	}))
+	defer ts.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(http.DefaultTransport, cassette)
+	rec.MaxBodySize = 8
+	c := &http.Client{Transport: rec}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := c.Get(ts.URL + "/stream")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+		b := make([]byte, 6)
+		_, err = io.ReadFull(resp.Body, b)
+		assert.NoError(t, err)
+		assert.Equal(t, "first ", string(b))
+		// body is passed to client before it is received completely
+		close(release)
+		b, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "second", string(b))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("response body is not streamed")
+	}
+
+	for _, path := range []string{"/large", "/small"} {
+		resp, err := c.Get(ts.URL + path)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	require.NoError(t, rec.Save())
+
+	rp, err := NewReplayer(cassette)
+	require.NoError(t, err)
+	c = &http.Client{Transport: rp}
+	resp, err := c.Get(ts.URL + "/small")
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "small", string(b))
+	for _, path := range []string{"/stream", "/large"} {
+		_, err = c.Get(ts.URL + path)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is larger than recording limit")
+	}
+}
+
+func TestReplayer_DrainsRequestBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "uploaded")
+	}))
+	defer ts.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecorder(http.DefaultTransport, cassette)
+	resp, err := (&http.Client{Transport: rec}).Post(ts.URL+"/upload", "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, rec.Save())
+
+	rp, err := NewReplayer(cassette)
+	require.NoError(t, err)
+	body := strings.NewReader(strings.Repeat("x", 100000))
+	resp, err = (&http.Client{Transport: rp}).Post(ts.URL+"/upload", "text/plain", body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Zero(t, body.Len())
+}
+
+func TestRecorder_Credentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "new-session"})
+		io.WriteString(w, "private")
+	}))
+	defer ts.Close()
+
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep %t", keep), func(t *testing.T) {
+			cassette := filepath.Join(t.TempDir(), "cassette.json")
+			rec := NewRecorder(http.DefaultTransport, cassette)
+			rec.KeepCredentials = keep
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "old-session"})
+			resp, err := (&http.Client{Transport: rec}).Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.NoError(t, rec.Save())
+
+			b, err := os.ReadFile(cassette)
+			require.NoError(t, err)
+			for _, secret := range []string{"Bearer token", "old-session", "new-session"} {
+				assert.Equal(t, keep, strings.Contains(string(b), secret), secret)
+			}
+
+			// redacted Authorization matches any token
+			rp, err := NewReplayer(cassette, "Authorization")
+			require.NoError(t, err)
+			c := &http.Client{Transport: rp}
+			req.Header.Set("Authorization", "Bearer other")
+			_, err = c.Do(req)
+			assert.Equal(t, keep, errors.Is(err, ErrNotRecorded), err)
+			req.Header.Del("Authorization")
+			_, err = c.Do(req)
+			assert.True(t, errors.Is(err, ErrNotRecorded), err)
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package roundtripper
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const harVersion = "1.2"
+
+// HAR is RoundTripper which records every request and response into HTTP
+// Archive 1.2, see http://www.softwareishard.com/blog/har-12-spec/.
+// Redirects and retries are recorded as separate entries when HAR wraps
+// transport below http.Client and retry RoundTripper.
+// Credential headers and cookie values are Redacted unless KeepCredentials is
+// set, so the archive can be shared.
+type HAR struct {
+	// KeepCredentials stores Authorization, Cookie, Set-Cookie and other
+	// credential headers and cookie values verbatim.
+	KeepCredentials bool
+
+	rt       http.RoundTripper
+	bodySize int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+// NewHAR returns HAR recorder of requests sent by rt. First bodySize bytes of
+// request and response bodies are stored, zero stores no body.
+func NewHAR(rt http.RoundTripper, bodySize int) *HAR {
+	return &HAR{
+		rt:       rt,
+		bodySize: bodySize,
+		now:      time.Now,
+	}
+}
+
+type harLog struct {
+	Log struct {
+		Version string       `json:"version"`
+		Creator harCreator   `json:"creator"`
+		Pages   []struct{}   `json:"pages"`
+		Entries []*harRecord `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harRecord struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// harTimings are in milliseconds, -1 means the phase does not apply.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harEntry is recorded exchange, it is completed when response body is read
+// or closed.
+type harEntry struct {
+	mu sync.Mutex
+
+	start                  time.Time
+	dnsStart, dnsDone      time.Time
+	connectStart, connDone time.Time
+	tlsStart, tlsDone      time.Time
+	gotConn                time.Time
+	wroteRequest           time.Time
+	firstByte              time.Time
+	end                    time.Time
+
+	req     *http.Request
+	reqBody *harBody
+	resp    *http.Response
+	body    *harBody
+	remote  string
+	err     error
+	// keepCredentials is HAR.KeepCredentials when the request was sent
+	keepCredentials bool
+}
+
+func (h *HAR) RoundTrip(r *http.Request) (*http.Response, error) {
+	e := &harEntry{start: h.now(), keepCredentials: h.KeepCredentials}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { e.set(&e.dnsStart, h.now()) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { e.set(&e.dnsDone, h.now()) },
+		ConnectStart:      func(string, string) { e.set(&e.connectStart, h.now()) },
+		ConnectDone:       func(string, string, error) { e.set(&e.connDone, h.now()) },
+		TLSHandshakeStart: func() { e.set(&e.tlsStart, h.now()) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { e.set(&e.tlsDone, h.now()) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.gotConn = h.now()
+			e.remote = info.Conn.RemoteAddr().String()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { e.set(&e.wroteRequest, h.now()) },
+		GotFirstResponseByte: func() { e.set(&e.firstByte, h.now()) },
+	}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+	if r.Body != nil && r.Body != http.NoBody {
+		e.reqBody = newHARBody(r.Body, h.bodySize, nil)
+		r.Body = e.reqBody
+	}
+	e.req = r
+	h.mu.Lock()
+	h.entries = append(h.entries, e)
+	h.mu.Unlock()
+
+	resp, err := h.rt.RoundTrip(r)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.err = err
+		e.end = h.now()
+		return nil, err
+	}
+	e.resp = resp
+	e.body = newHARBody(resp.Body, h.bodySize, func() { e.set(&e.end, h.now()) })
+	resp.Body = e.body
+	return resp, nil
+}
+
+func (e *harEntry) set(t *time.Time, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t.IsZero() {
+		*t = now
+	}
+}
+
+// Save writes recorded entries into file name.
+func (h *HAR) Save(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("unable to create HAR file: %w", err)
+	}
+	if err := h.Write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write HAR file: %w", err)
+	}
+	return f.Close()
+}
+
+// Write writes recorded entries as HAR JSON into w.
+func (h *HAR) Write(w io.Writer) error {
+	var l harLog
+	l.Log.Version = harVersion
+	l.Log.Creator = harCreator{Name: "curly", Version: "1.0"}
+	l.Log.Pages = []struct{}{}
+	l.Log.Entries = []*harRecord{}
+
+	h.mu.Lock()
+	entries := append([]*harEntry(nil), h.entries...)
+	h.mu.Unlock()
+	for _, e := range entries {
+		l.Log.Entries = append(l.Log.Entries, e.record())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+func (e *harEntry) record() *harRecord {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rec := &harRecord{
+		StartedDateTime: e.start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Request: harRequest{
+			Method:      e.req.Method,
+			URL:         e.req.URL.String(),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.header(e.req.Header)),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: e.timings(),
+	}
+	if e.err != nil {
+		rec.Error = e.err.Error()
+	}
+	if e.remote != "" {
+		host, port, _ := net.SplitHostPort(e.remote)
+		rec.ServerIPAddress, rec.Connection = host, port
+	}
+	for _, c := range e.req.Cookies() {
+		rec.Request.Cookies = append(rec.Request.Cookies, harNameValue{Name: c.Name, Value: e.cookie(c)})
+	}
+	rec.Request.QueryString = harHeaders(http.Header(e.req.URL.Query()))
+	if b := e.reqBody; b != nil {
+		text, encoding, comment, size := b.content()
+		rec.Request.BodySize = size
+		if encoding != "" {
+			comment = "text is " + encoding + " encoded" + commentSep(comment)
+		}
+		rec.Request.PostData = &harPostData{
+			MimeType: e.req.Header.Get("Content-Type"),
+			Text:     text,
+			Comment:  comment,
+		}
+	}
+
+	if resp := e.resp; resp != nil {
+		rec.Request.HTTPVersion = resp.Proto
+		rec.Response.Status = resp.StatusCode
+		rec.Response.StatusText = http.StatusText(resp.StatusCode)
+		rec.Response.HTTPVersion = resp.Proto
+		rec.Response.Headers = harHeaders(e.header(resp.Header))
+		for _, c := range resp.Cookies() {
+			rec.Response.Cookies = append(rec.Response.Cookies, harNameValue{Name: c.Name, Value: e.cookie(c)})
+		}
+		rec.Response.RedirectURL = resp.Header.Get("Location")
+		text, encoding, comment, size := e.body.content()
+		rec.Response.BodySize = size
+		rec.Response.Content = harContent{
+			Size:     size,
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+	}
+
+	t := rec.Timings
+	for _, d := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if d > 0 {
+			rec.Time += d
+		}
+	}
+	rec.Time = roundMS(rec.Time)
+	return rec
+}
+
+// header returns h with redacted credentials unless they are kept.
+func (e *harEntry) header(h http.Header) http.Header {
+	if e.keepCredentials {
+		return h
+	}
+	return redactHeader(h)
+}
+
+// cookie returns value of c, it is redacted unless credentials are kept.
+func (e *harEntry) cookie(c *http.Cookie) string {
+	if e.keepCredentials {
+		return c.Value
+	}
+	return Redacted
+}
+
+func commentSep(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return ", " + comment
+}
+
+func (e *harEntry) timings() harTimings {
+	t := harTimings{
+		Blocked: -1,
+		DNS:     ms(e.dnsStart, e.dnsDone),
+		Connect: ms(e.connectStart, e.connDone),
+		Send:    ms(e.gotConn, e.wroteRequest),
+		Wait:    ms(e.wroteRequest, e.firstByte),
+		Receive: ms(e.firstByte, e.end),
+		SSL:     ms(e.tlsStart, e.tlsDone),
+	}
+	// connect time includes TLS handshake
+	if t.SSL > 0 && t.Connect >= 0 {
+		t.Connect += t.SSL
+	}
+	if blocked := ms(e.start, e.gotConn); blocked >= 0 {
+		for _, d := range []float64{t.DNS, t.Connect} {
+			if d > 0 {
+				blocked -= d
+			}
+		}
+		if blocked < 0 {
+			blocked = 0
+		}
+		t.Blocked = roundMS(blocked)
+	}
+	return t
+}
+
+// roundMS rounds sum of milliseconds to microseconds.
+func roundMS(d float64) float64 {
+	return math.Round(d*1000) / 1000
+}
+
+// ms returns milliseconds between start and end, -1 if any is unknown.
+func ms(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
+
+// harHeaders returns name value pairs of h sorted by name, it is used for
+// query parameters as well.
+func harHeaders(h http.Header) []harNameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, v := range h[name] {
+			headers = append(headers, harNameValue{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+// harBody counts bytes read from body and keeps the first limit bytes.
+type harBody struct {
+	rc    io.ReadCloser
+	limit int
+	done  func()
+	once  sync.Once
+
+	mu  sync.Mutex
+	buf []byte
+	n   int64
+}
+
+func newHARBody(rc io.ReadCloser, limit int, done func()) *harBody {
+	return &harBody{rc: rc, limit: limit, done: done}
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.mu.Lock()
+	b.n += int64(n)
+	if keep := b.limit - len(b.buf); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+		b.buf = append(b.buf, p[:keep]...)
+	}
+	b.mu.Unlock()
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.finish()
+	return b.rc.Close()
+}
+
+func (b *harBody) finish() {
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+}
+
+// content returns stored bytes as text, binary content is base64 encoded.
+// size is number of bytes read so far.
+func (b *harBody) content() (text, encoding, comment string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	size = b.n
+	if len(b.buf) == 0 {
+		return "", "", "", size
+	}
+	if int64(len(b.buf)) < b.n {
+		comment = fmt.Sprintf("truncated to first %d of %d bytes", len(b.buf), b.n)
+	}
+	if utf8.Valid(b.buf) {
+		return string(b.buf), "", comment, size
+	}
+	return base64.StdEncoding.EncodeToString(b.buf), "base64", comment, size
+}
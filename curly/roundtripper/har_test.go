@@ -0,0 +1,149 @@
+package roundtripper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAR(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new?a=1", http.StatusFound)
+		case "/new":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "new content")
+		case "/upload":
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte{0xff, 0xfe})
+		}
+	}))
+	defer ts.Close()
+
+	har := NewHAR(http.DefaultTransport, 5)
+	c := &http.Client{Transport: har}
+
+	resp, err := c.Get(ts.URL + "/old")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	resp, err = c.Post(ts.URL+"/upload", "text/plain", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, err = c.Get(closed.URL)
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, har.Write(&buf))
+	var got harLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+
+	assert.Equal(t, "1.2", got.Log.Version)
+	assert.Equal(t, "curly", got.Log.Creator.Name)
+	require.Len(t, got.Log.Entries, 4)
+
+	redirect, page, upload, failed := got.Log.Entries[0], got.Log.Entries[1], got.Log.Entries[2], got.Log.Entries[3]
+	assert.Equal(t, http.StatusFound, redirect.Response.Status)
+	assert.Equal(t, "/new?a=1", redirect.Response.RedirectURL)
+	assert.Equal(t, "HTTP/1.1", redirect.Response.HTTPVersion)
+	assert.GreaterOrEqual(t, redirect.Timings.Connect, 0.0)
+	assert.Equal(t, -1.0, redirect.Timings.SSL)
+	assert.Equal(t, "127.0.0.1", redirect.ServerIPAddress)
+
+	assert.Equal(t, ts.URL+"/new?a=1", page.Request.URL)
+	assert.Equal(t, []harNameValue{{Name: "a", Value: "1"}}, page.Request.QueryString)
+	assert.Equal(t, []harNameValue{{Name: "session", Value: Redacted}}, page.Response.Cookies)
+	assert.Equal(t, harContent{
+		Size:     int64(len("new content")),
+		MimeType: "text/plain",
+		Text:     "new c",
+		Comment:  "truncated to first 5 of 11 bytes",
+	}, page.Response.Content)
+	// connection of redirect is reused
+	assert.Equal(t, -1.0, page.Timings.Connect)
+	assert.GreaterOrEqual(t, page.Timings.Wait, 0.0)
+	assert.GreaterOrEqual(t, page.Timings.Receive, 0.0)
+	assert.Greater(t, page.Time, 0.0)
+
+	assert.Equal(t, http.MethodPost, upload.Request.Method)
+	assert.Equal(t, int64(len("hello world")), upload.Request.BodySize)
+	require.NotNil(t, upload.Request.PostData)
+	assert.Equal(t, harPostData{MimeType: "text/plain", Text: "hello", Comment: "truncated to first 5 of 11 bytes"}, *upload.Request.PostData)
+	assert.Equal(t, "//4=", upload.Response.Content.Text)
+	assert.Equal(t, "base64", upload.Response.Content.Encoding)
+
+	assert.Equal(t, 0, failed.Response.Status)
+	assert.Contains(t, failed.Error, "connection refused")
+}
+
+func TestHAR_Credentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "new-session"})
+		w.Header().Set("X-Request-Id", "42")
+	}))
+	defer ts.Close()
+
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep %t", keep), func(t *testing.T) {
+			har := NewHAR(http.DefaultTransport, 0)
+			har.KeepCredentials = keep
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("Accept", "text/plain")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "old-session"})
+			resp, err := (&http.Client{Transport: har}).Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			var buf bytes.Buffer
+			require.NoError(t, har.Write(&buf))
+			var got harLog
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+			require.Len(t, got.Log.Entries, 1)
+			e := got.Log.Entries[0]
+
+			want := func(value string) string {
+				if keep {
+					return value
+				}
+				return Redacted
+			}
+			assert.Contains(t, e.Request.Headers, harNameValue{Name: "Authorization", Value: want("Bearer token")})
+			assert.Contains(t, e.Request.Headers, harNameValue{Name: "Cookie", Value: want("session=old-session")})
+			assert.Contains(t, e.Request.Headers, harNameValue{Name: "Accept", Value: "text/plain"})
+			assert.Equal(t, []harNameValue{{Name: "session", Value: want("old-session")}}, e.Request.Cookies)
+			assert.Contains(t, e.Response.Headers, harNameValue{Name: "Set-Cookie", Value: want("session=new-session")})
+			assert.Contains(t, e.Response.Headers, harNameValue{Name: "X-Request-Id", Value: "42"})
+			assert.Equal(t, []harNameValue{{Name: "session", Value: want("new-session")}}, e.Response.Cookies)
+			if !keep {
+				for _, secret := range []string{"Bearer token", "old-session", "new-session"} {
+					assert.NotContains(t, buf.String(), secret)
+				}
+			}
+		})
+	}
+}
+
+func TestHAR_Save(t *testing.T) {
+	har := NewHAR(http.DefaultTransport, 0)
+	name := filepath.Join(t.TempDir(), "empty.har")
+	require.NoError(t, har.Save(name))
+	assert.FileExists(t, name)
+}
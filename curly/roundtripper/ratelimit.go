@@ -0,0 +1,66 @@
+package roundtripper
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/ratelimit"
+)
+
+type rateLimitTransport struct {
+	rt       http.RoundTripper
+	download *ratelimit.Limiter
+	upload   *ratelimit.Limiter
+}
+
+// NewRateLimit returns RoundTripper which throttles response bodies by
+// download limiter and request bodies by upload limiter, nil limiter does not
+// throttle. Limiters are shared by all requests, so parallel requests together
+// keep to the rate.
+func NewRateLimit(rt http.RoundTripper, download, upload *ratelimit.Limiter) http.RoundTripper {
+	return &rateLimitTransport{
+		rt:       rt,
+		download: download,
+		upload:   upload,
+	}
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func (t *rateLimitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.upload != nil && r.Body != nil && r.Body != http.NoBody {
+		body, getBody := r.Body, r.GetBody
+		r = r.Clone(r.Context())
+		ctx := r.Context()
+		r.Body = limit(ctx, body, t.upload)
+		// body sent again by transport is throttled as well
+		if getBody != nil {
+			r.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return limit(ctx, body, t.upload), nil
+			}
+		}
+	}
+	resp, err := t.rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if t.download != nil {
+		resp.Body = limit(r.Context(), resp.Body, t.download)
+	}
+	return resp, nil
+}
+
+func limit(ctx context.Context, body io.ReadCloser, l *ratelimit.Limiter) io.ReadCloser {
+	return limitedBody{
+		Reader: ratelimit.NewReader(ctx, body, l),
+		Closer: body,
+	}
+}
@@ -0,0 +1,78 @@
+package roundtripper
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 4_000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		download *ratelimit.Limiter
+		upload   *ratelimit.Limiter
+		minTime  time.Duration
+	}{
+		{name: "no limit"},
+		{name: "download", download: ratelimit.NewLimiter(100_000), minTime: 300 * time.Millisecond},
+		{name: "upload", upload: ratelimit.NewLimiter(100_000), minTime: 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &http.Client{Transport: NewRateLimit(http.DefaultTransport, tt.download, tt.upload)}
+			start := time.Now()
+			resp, err := c.Post(ts.URL, "application/octet-stream", bytes.NewReader(content))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.True(t, bytes.Equal(content, got))
+			assert.GreaterOrEqual(t, time.Since(start), tt.minTime)
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRateLimit_GetBody(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 4_000)
+	// transport sends body again after connection of reused request fails
+	rt := NewRateLimit(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		_, err := io.Copy(io.Discard, r.Body)
+		require.NoError(t, err)
+		body, err := r.GetBody()
+		require.NoError(t, err)
+		got, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(content, got))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), nil, ratelimit.NewLimiter(100_000))
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", bytes.NewReader(content))
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 700*time.Millisecond)
+}
@@ -0,0 +1,28 @@
+package roundtripper
+
+import "net/http"
+
+// Redacted replaces values of credentials in recorded requests and responses.
+const Redacted = "REDACTED"
+
+// credentialHeaders carry credentials or session cookies, e.g. bearer tokens,
+// basic auth, SigV4 signatures and session tokens.
+var credentialHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Amz-Security-Token",
+}
+
+// redactHeader returns copy of h with values of credential headers replaced
+// by Redacted.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range credentialHeaders {
+		for i := range h[name] {
+			h[name][i] = Redacted
+		}
+	}
+	return h
+}
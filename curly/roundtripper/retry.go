@@ -0,0 +1,201 @@
+package roundtripper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+
+	// drainLimit is the most bytes read from a response which is retried, so
+	// the connection can be reused.
+	drainLimit = 64 << 10
+)
+
+// RetryPolicy configures NewRetry.
+type RetryPolicy struct {
+	// MaxRetries is number of retries after the first attempt.
+	MaxRetries int
+	// MaxTime caps total time spent by retrying, zero means no limit.
+	MaxTime time.Duration
+	// MinBackoff is backoff before the first retry, it doubles with every
+	// retry up to MaxBackoff. Defaults are 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Logger logs every retried attempt, it is optional.
+	Logger Logger
+}
+
+// noRetryKey is context key of requests which are not retried.
+type noRetryKey struct{}
+
+// WithoutRetry returns context whose requests are sent only once by NewRetry,
+// it is used by callers which retry on their own, e.g. S3 and tus uploads.
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+type retryTransport struct {
+	rt     http.RoundTripper
+	policy RetryPolicy
+	now    func() time.Time
+	sleep  func(r *http.Request, d time.Duration) error
+}
+
+// NewRetry returns RoundTripper which retries idempotent requests failed by
+// connection error, 429 Too Many Requests or 5xx status. Attempts are delayed
+// by jittered exponential backoff or by Retry-After header of the response.
+// Request body is rewound by GetBody, requests with body which can not be
+// replayed are sent only once, so are requests with context of WithoutRetry.
+func NewRetry(rt http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = defaultMinBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxBackoff
+	}
+	return &retryTransport{
+		rt:     rt,
+		policy: policy,
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !t.replayable(r) {
+		return t.rt.RoundTrip(r)
+	}
+
+	start := t.now()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.rt.RoundTrip(r)
+		if attempt >= t.policy.MaxRetries || !retryable(r, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp, t.now()); ok {
+				wait = d
+			}
+		}
+		if t.policy.MaxTime > 0 && t.now().Add(wait).Sub(start) > t.policy.MaxTime {
+			t.logf("retry of %s %s gave up after %d attempts: max retry time %s exceeded", r.Method, r.URL, attempt+1, t.policy.MaxTime)
+			return resp, err
+		}
+		t.logf("attempt %d of %s %s failed: %s, retrying in %s", attempt+1, r.Method, r.URL, failure(resp, err), wait)
+
+		if resp != nil {
+			io.CopyN(io.Discard, resp.Body, drainLimit)
+			resp.Body.Close()
+		}
+		if err := t.sleep(r, wait); err != nil {
+			return nil, err
+		}
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("unable to rewind request body: %w", err)
+			}
+			r = r.Clone(r.Context())
+			r.Body = body
+		}
+	}
+}
+
+// replayable returns true if request is idempotent and its body can be sent
+// again.
+func (t *retryTransport) replayable(r *http.Request) bool {
+	if t.policy.MaxRetries <= 0 || r.Context().Value(noRetryKey{}) != nil {
+		return false
+	}
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		// same as net/http, request with idempotency key can be retried
+		if _, ok := r.Header["Idempotency-Key"]; !ok {
+			if _, ok := r.Header["X-Idempotency-Key"]; !ok {
+				return false
+			}
+		}
+	}
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		t.logf("body of %s %s can not be replayed, request is not retried", r.Method, r.URL)
+		return false
+	}
+	return true
+}
+
+func retryable(r *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// canceled request or expired deadline are not retried
+		return r.Context().Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+}
+
+func failure(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// backoff returns delay before retry, it is random value between half and
+// full exponential backoff.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.policy.MaxBackoff
+	if attempt < 32 && t.policy.MinBackoff<<attempt < d {
+		d = t.policy.MinBackoff << attempt
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses Retry-After header which is either number of seconds or
+// HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	date, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := date.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func (t *retryTransport) logf(format string, args ...interface{}) {
+	if t.policy.Logger != nil {
+		t.policy.Logger.Debugf(format, args...)
+	}
+}
+
+func sleepContext(r *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
@@ -0,0 +1,241 @@
+package roundtripper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+// newTestRetry returns retry transport which records sleeps instead of
+// sleeping.
+func newTestRetry(policy RetryPolicy, slept *[]time.Duration) http.RoundTripper {
+	rt := NewRetry(http.DefaultTransport, policy).(*retryTransport)
+	rt.sleep = func(r *http.Request, d time.Duration) error {
+		*slept = append(*slept, d)
+		return nil
+	}
+	return rt
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retryAfter   string
+		method       string
+		body         func() io.Reader
+		policy       RetryPolicy
+		wantStatus   int
+		wantAttempts int
+		wantSleep    time.Duration
+	}{
+		{
+			name:         "success after 5xx",
+			statuses:     []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "retries exhausted",
+			statuses:     []int{500, 500, 500, 500},
+			policy:       RetryPolicy{MaxRetries: 2},
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 3,
+		},
+		{
+			name:         "client error is not retried",
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3},
+			wantStatus:   http.StatusNotFound,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry after seconds",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:   "7",
+			policy:       RetryPolicy{MaxRetries: 1},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 2,
+			wantSleep:    7 * time.Second,
+		},
+		{
+			name:         "retry after exceeds max time",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:   "120",
+			policy:       RetryPolicy{MaxRetries: 1, MaxTime: time.Minute},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+		},
+		{
+			name:         "post is not idempotent",
+			statuses:     []int{500, http.StatusOK},
+			method:       http.MethodPost,
+			body:         func() io.Reader { return strings.NewReader("hello") },
+			policy:       RetryPolicy{MaxRetries: 3},
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+		{
+			name:         "body is rewound",
+			statuses:     []int{500, http.StatusOK},
+			method:       http.MethodPut,
+			body:         func() io.Reader { return strings.NewReader("hello") },
+			policy:       RetryPolicy{MaxRetries: 3},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 2,
+		},
+		{
+			name:     "streaming body is not replayed",
+			statuses: []int{500, http.StatusOK},
+			method:   http.MethodPut,
+			body: func() io.Reader {
+				return io.MultiReader(strings.NewReader("hel"), strings.NewReader("lo"))
+			},
+			policy:       RetryPolicy{MaxRetries: 3},
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&attempts, 1) - 1
+				if r.Body != nil {
+					b, _ := io.ReadAll(r.Body)
+					if tt.body != nil {
+						assert.Equal(t, "hello", string(b))
+					}
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer ts.Close()
+
+			var slept []time.Duration
+			log := &testLogger{}
+			tt.policy.Logger = log
+			c := &http.Client{Transport: newTestRetry(tt.policy, &slept)}
+
+			var body io.Reader
+			if tt.body != nil {
+				body = tt.body()
+			}
+			req, err := http.NewRequest(tt.method, ts.URL, body)
+			require.NoError(t, err)
+			resp, err := c.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantAttempts, int(attempts))
+			assert.Len(t, slept, tt.wantAttempts-1)
+			if tt.wantSleep > 0 {
+				assert.Equal(t, tt.wantSleep, slept[0])
+			}
+			assert.GreaterOrEqual(t, len(log.lines), tt.wantAttempts-1)
+		})
+	}
+}
+
+func TestRetry_ConnectionError(t *testing.T) {
+	// closed listener refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var slept []time.Duration
+	c := &http.Client{Transport: newTestRetry(RetryPolicy{MaxRetries: 4, MinBackoff: time.Second, MaxBackoff: 3 * time.Second}, &slept)}
+	_, err = c.Get("http://" + addr)
+	assert.Error(t, err)
+	require.Len(t, slept, 4)
+
+	// jittered exponential backoff capped by MaxBackoff
+	for i, max := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		assert.GreaterOrEqual(t, slept[i], max/2)
+		assert.LessOrEqual(t, slept[i], max)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: "Mon, 01 Mar 2021 10:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Mon, 01 Mar 2021 09:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Retry-After", tt.value)
+			got, ok := retryAfter(resp, now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRetry_Canceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := &http.Client{
+		Transport: NewRetry(http.DefaultTransport, RetryPolicy{MaxRetries: 10, MinBackoff: time.Hour}),
+		Timeout:   100 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRetry_WithoutRetry(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var slept []time.Duration
+	c := &http.Client{Transport: newTestRetry(RetryPolicy{MaxRetries: 3}, &slept)}
+	req, err := http.NewRequestWithContext(WithoutRetry(context.Background()), http.MethodPut, ts.URL, strings.NewReader("part"))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Empty(t, slept)
+}
@@ -1,6 +1,8 @@
 package roundtripper
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -40,6 +42,35 @@ func (dt *debugTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 		return nil, fmt.Errorf("unable to dump rt response: %w", err)
 	}
 	dt.l.Debugf("response: %v", string(b))
+	if resp.TLS != nil {
+		dt.logTLS(resp.TLS)
+	}
 
 	return resp, nil
 }
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// logTLS logs negotiated TLS version, cipher suite, application protocol and
+// certificate chain of the server.
+func (dt *debugTransport) logTLS(cs *tls.ConnectionState) {
+	version, ok := tlsVersions[cs.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", cs.Version)
+	}
+	protocol := cs.NegotiatedProtocol
+	if protocol == "" {
+		protocol = "http/1.1"
+	}
+	dt.l.Debugf("tls: %s, cipher %s, protocol %s, server %s, resumed %t",
+		version, tls.CipherSuiteName(cs.CipherSuite), protocol, cs.ServerName, cs.DidResume)
+	for i, cert := range cs.PeerCertificates {
+		dt.l.Debugf("tls certificate %d: subject %q, issuer %q, valid %s - %s, sha256 %x",
+			i, cert.Subject, cert.Issuer, cert.NotBefore.UTC().Format("2006-01-02"), cert.NotAfter.UTC().Format("2006-01-02"), sha256.Sum256(cert.Raw))
+	}
+}
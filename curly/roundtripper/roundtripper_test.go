@@ -0,0 +1,27 @@
+package roundtripper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebug_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	l := &testLogger{}
+	c := &http.Client{Transport: NewDebug(ts.Client().Transport, l)}
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	log := strings.Join(l.lines, "\n")
+	assert.Contains(t, log, "tls: TLS 1.3, cipher TLS_")
+	assert.Contains(t, log, "protocol http/1.1")
+	assert.Contains(t, log, `tls certificate 0: subject "O=Acme Co", issuer "O=Acme Co"`)
+}
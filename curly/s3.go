@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request"
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/sigv4"
+	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// minS3PartSize and maxS3PartSize are limits of S3 multipart upload part.
+	minS3PartSize = 5 << 20
+	maxS3PartSize = 5 << 30
+)
+
+// s3Flags are flags of -upload=s3://bucket/key, region and credentials
+// default to AWS environment variables.
+type s3Flags struct {
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	partSize     string
+}
+
+func (f *s3Flags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.endpoint, "s3-endpoint", "", "URL of S3-compatible storage of -upload=s3://bucket/key, buckets are addressed by path, default is AWS endpoint of -s3-region")
+	fs.StringVar(&f.region, "s3-region", "", "region of -upload=s3://bucket/key, default is AWS_REGION env or "+defaultS3Region)
+	fs.StringVar(&f.accessKey, "s3-access-key", "", "access key ID of -upload=s3://bucket/key, default is AWS_ACCESS_KEY_ID env")
+	fs.StringVar(&f.secretKey, "s3-secret-key", "", "secret access key of -upload=s3://bucket/key, default is AWS_SECRET_ACCESS_KEY env")
+	fs.StringVar(&f.sessionToken, "s3-session-token", "", "session token of temporary credentials, default is AWS_SESSION_TOKEN env")
+	fs.StringVar(&f.partSize, "s3-part-size", "", "size of S3 multipart upload part kept in memory, e.g. 16M, at least 5M, default 8M")
+}
+
+// apply sets S3 options of cfg when upload URL is S3 URL.
+func (f *s3Flags) apply(cfg *Config) error {
+	if !cfg.Upload || cfg.UploadURL == nil || cfg.UploadURL.Scheme != s3Scheme {
+		if *f != (s3Flags{}) {
+			return fmt.Errorf("-s3-* flags require -upload=s3://bucket/key")
+		}
+		return nil
+	}
+	if cfg.UploadProtocol == uploadTus {
+		return fmt.Errorf("-upload-protocol=tus can not be combined with -upload=s3://bucket/key")
+	}
+	if cfg.Batch != nil && !strings.HasSuffix("/"+cfg.UploadURL.Path, "/") {
+		return fmt.Errorf("-upload=s3://bucket/key of more URLs requires key prefix ending with /")
+	}
+
+	cfg.S3 = request.S3Options{
+		Endpoint: f.endpoint,
+		Region:   firstNonEmpty(f.region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), defaultS3Region),
+		Credentials: sigv4.Credentials{
+			AccessKeyID:     firstNonEmpty(f.accessKey, os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretAccessKey: firstNonEmpty(f.secretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			SessionToken:    firstNonEmpty(f.sessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+		},
+	}
+	if cfg.S3.Credentials.AccessKeyID == "" || cfg.S3.Credentials.SecretAccessKey == "" {
+		return fmt.Errorf("-upload=s3://bucket/key requires -s3-access-key and -s3-secret-key or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env")
+	}
+
+	if f.partSize != "" {
+		size, err := parseSize(f.partSize)
+		if err != nil {
+			return fmt.Errorf("invalid -s3-part-size: %w", err)
+		}
+		if size < minS3PartSize || size > maxS3PartSize {
+			return fmt.Errorf("-s3-part-size must be between 5M and 5G, got %d bytes", size)
+		}
+		cfg.S3PartSize = int(size)
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// s3Upload streams gzipped, optionally encrypted, download into S3 object
+// cfg.UploadURL, key ending with / is prefix of uploaded file name.
+type s3Upload struct {
+	u      *request.S3Uploader
+	bucket string
+	key    string
+	gz     io.Closer
+	body   io.Reader
+}
+
+// newS3Upload prepares upload of r, content is read by Upload.
+func newS3Upload(c *http.Client, cfg *Config, r io.Reader, p *progress) (*s3Upload, error) {
+	u, err := request.NewS3Uploader(c, cfg.S3)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.S3PartSize > 0 {
+		u.PartSize = cfg.S3PartSize
+	}
+	key := strings.TrimPrefix(cfg.UploadURL.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += uploadName(cfg)
+	}
+	gz, body := uploadBody(r, cfg, p)
+	return &s3Upload{u: u, bucket: cfg.UploadURL.Host, key: key, gz: gz, body: body}, nil
+}
+
+// Upload sends the content by parts. It returns URL of the object.
+func (s *s3Upload) Upload(ctx context.Context) (string, error) {
+	defer s.gz.Close()
+	// failed requests are retried by uploader, -retry would multiply its retries
+	return s.u.Upload(roundtripper.WithoutRetry(ctx), s.bucket, s.key, s.body)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"flag"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request"
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/s3test"
+)
+
+// setenv sets environment variable key for the test, original value is
+// restored by cleanup.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestS3Flags(t *testing.T) {
+	setenv(t, "AWS_ACCESS_KEY_ID", "")
+	setenv(t, "AWS_SECRET_ACCESS_KEY", "env-secret")
+	setenv(t, "AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "s3", args: []string{"-upload=s3://bucket/key", "-s3-access-key", "AK", "-s3-part-size", "16M"}},
+		{name: "bool upload", args: []string{"-upload", "-s3-region", "us-east-1"}, wantErr: "-s3-* flags require -upload=s3://bucket/key"},
+		{name: "no credentials", args: []string{"-upload=s3://bucket/key"}, wantErr: "-upload=s3://bucket/key requires -s3-access-key"},
+		{name: "small part", args: []string{"-upload=s3://bucket/key", "-s3-access-key", "AK", "-s3-part-size", "1M"}, wantErr: "-s3-part-size must be between 5M and 5G"},
+		{name: "tus", args: []string{"-upload=s3://bucket/key", "-s3-access-key", "AK", "-upload-protocol", "tus"}, wantErr: "-upload-protocol=tus can not be combined"},
+		{name: "invalid upload", args: []string{"-upload=http://bucket/key"}, wantErr: "expected true, false or s3://bucket/key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			var f s3Flags
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.Var(uploadFlag{cfg}, "upload", "")
+			fs.StringVar(&cfg.UploadProtocol, "upload-protocol", uploadMultipart, "")
+			f.register(fs)
+
+			err := fs.Parse(tt.args)
+			if err == nil {
+				err = f.apply(cfg)
+			}
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, cfg.Upload)
+			assert.Equal(t, "s3://bucket/key", cfg.UploadURL.String())
+			assert.Equal(t, "eu-west-1", cfg.S3.Region)
+			assert.Equal(t, "AK", cfg.S3.Credentials.AccessKeyID)
+			assert.Equal(t, "env-secret", cfg.S3.Credentials.SecretAccessKey)
+			assert.Equal(t, 16<<20, cfg.S3PartSize)
+		})
+	}
+}
+
+func TestDownload_UploadS3(t *testing.T) {
+	content := strings.Repeat("s3 content ", 10000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer ts.Close()
+	s := s3test.NewServer()
+	defer s.Close()
+	s.MinPartSize = 50
+	// the second part is retried
+	s.FailPart(func(part, attempt int) int {
+		if part == 2 && attempt == 1 {
+			return http.StatusInternalServerError
+		}
+		return 0
+	})
+
+	downloadURL, err := url.Parse(ts.URL + "/file.txt")
+	require.NoError(t, err)
+	uploadURL, err := url.Parse("s3://bucket/dir/")
+	require.NoError(t, err)
+	cfg := &Config{
+		DownloadURL: downloadURL,
+		Upload:      true,
+		UploadURL:   uploadURL,
+		S3: request.S3Options{
+			Endpoint:    s.URL,
+			Region:      s3test.Region,
+			Credentials: s3test.Credentials,
+		},
+		S3PartSize: 50,
+		Std:        stdnull,
+		Progress:   progressNone,
+	}
+	n, err := download(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+
+	b, ok := s.Object("bucket", "dir/file.txt.gz")
+	require.True(t, ok)
+	assert.Greater(t, len(b), 100)
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+	assert.Empty(t, s.Uploads())
+}
+
+func TestDownload_UploadS3Slow(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	content := hex.EncodeToString(random)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer ts.Close()
+	s := s3test.NewServer()
+	defer s.Close()
+	// parts are uploaded after -max-time of the download has passed
+	s.DelayPart(100 * time.Millisecond)
+
+	downloadURL, err := url.Parse(ts.URL + "/file.txt")
+	require.NoError(t, err)
+	uploadURL, err := url.Parse("s3://bucket/file.gz")
+	require.NoError(t, err)
+	cfg := &Config{
+		DownloadURL: downloadURL,
+		Upload:      true,
+		UploadURL:   uploadURL,
+		S3: request.S3Options{
+			Endpoint:    s.URL,
+			Region:      s3test.Region,
+			Credentials: s3test.Credentials,
+		},
+		S3PartSize: 500,
+		MaxTime:    200 * time.Millisecond,
+		Std:        stdnull,
+		Progress:   progressNone,
+	}
+	_, err = download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+	require.NoError(t, err)
+
+	b, ok := s.Object("bucket", "file.gz")
+	require.True(t, ok)
+	assert.Greater(t, len(b), 1000)
+	assert.Empty(t, s.Aborted())
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// probeRanges sends HEAD request with header h and returns content length of u
+// when server supports byte range requests. ok is false if ranges are not supported or the
+// length is unknown.
+func probeRanges(ctx context.Context, c *http.Client, u *url.URL, h http.Header) (size int64, ok bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	setHeader(req, h)
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to probe ranges: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, nil
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= 0 {
+		return 0, false, nil
+	}
+	return resp.ContentLength, true, nil
+}
+
+type segment struct {
+	start   int64
+	size    int64
+	written int64
+}
+
+var _ io.ReadCloser = (*segmented)(nil)
+
+// segmented downloads byte ranges of one resource concurrently. Every range is
+// written at its offset into file f, Read returns the content of f in order as
+// soon as the bytes are available so the stream can still be hashed and chunked.
+type segmented struct {
+	f        *os.File
+	segments []segment
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	err  error
+	cur  int
+	pos  int64
+}
+
+// newSegmented starts n concurrent range requests with header h for resource u
+// of given size.
+func newSegmented(ctx context.Context, c *http.Client, u *url.URL, h http.Header, f *os.File, size int64, n int) (*segmented, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of segments: %d", n)
+	}
+	if int64(n) > size {
+		n = int(size)
+	}
+	if err := f.Truncate(size); err != nil {
+		return nil, fmt.Errorf("unable to allocate output file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &segmented{
+		f:        f,
+		segments: make([]segment, n),
+		cancel:   cancel,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	segSize := size / int64(n)
+	for i := range s.segments {
+		s.segments[i].start = int64(i) * segSize
+		s.segments[i].size = segSize
+	}
+	// last segment takes the remainder
+	s.segments[n-1].size = size - s.segments[n-1].start
+
+	for i := range s.segments {
+		s.wg.Add(1)
+		go func(i int) {
+			defer s.wg.Done()
+			if err := s.fetch(ctx, c, u, h, i); err != nil {
+				s.fail(fmt.Errorf("segment %d: %w", i, err))
+			}
+		}(i)
+	}
+	return s, nil
+}
+
+func (s *segmented) fetch(ctx context.Context, c *http.Client, u *url.URL, h http.Header, i int) error {
+	seg := s.segments[i]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	setHeader(req, h)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.start, seg.start+seg.size-1))
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	start, err := contentRangeStart(resp)
+	if err != nil {
+		return err
+	}
+	if start != seg.start {
+		return fmt.Errorf("server returned range starting at %d, expected %d", start, seg.start)
+	}
+
+	buf := make([]byte, 32*1024)
+	r := io.LimitReader(resp.Body, seg.size)
+	off := seg.start
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := s.f.WriteAt(buf[:n], off); err != nil {
+				return fmt.Errorf("unable to write segment: %w", err)
+			}
+			off += int64(n)
+			s.mu.Lock()
+			s.segments[i].written += int64(n)
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if written := off - seg.start; written != seg.size {
+		return fmt.Errorf("short segment: got %d bytes, expected %d", written, seg.size)
+	}
+	return nil
+}
+
+func (s *segmented) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.cancel()
+	}
+	s.cond.Broadcast()
+}
+
+// Read blocks until bytes at current position are downloaded.
+func (s *segmented) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	var avail int64
+	for {
+		if s.cur == len(s.segments) {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		seg := s.segments[s.cur]
+		avail = seg.start + seg.written - s.pos
+		if avail > 0 {
+			break
+		}
+		if seg.written == seg.size {
+			s.cur++
+			continue
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+
+	if int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	n, err := s.f.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// Close cancels pending range requests and waits for them to finish.
+func (s *segmented) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
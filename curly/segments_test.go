@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
+)
+
+func TestSegmented(t *testing.T) {
+	content := make([]byte, 100_003)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name     string
+		segments int
+	}{
+		{name: "single segment", segments: 1},
+		{name: "4 segments", segments: 4},
+		{name: "7 segments not dividing content evenly", segments: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					atomic.AddInt32(&ranges, 1)
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			require.NoError(t, err)
+
+			size, ok, err := probeRanges(context.Background(), ts.Client(), u, nil)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, int64(len(content)), size)
+
+			f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			seg, err := newSegmented(context.Background(), ts.Client(), u, nil, f, size, tt.segments)
+			require.NoError(t, err)
+			got, err := io.ReadAll(seg)
+			require.NoError(t, err)
+			require.NoError(t, seg.Close())
+
+			assert.Equal(t, content, got)
+			assert.Equal(t, int32(tt.segments), ranges)
+
+			onDisk, err := os.ReadFile(f.Name())
+			require.NoError(t, err)
+			assert.Equal(t, content, onDisk)
+		})
+	}
+}
+
+func TestSegmented_Error(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// server ignores the range and returns whole content
+		w.Write(content)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	seg, err := newSegmented(context.Background(), ts.Client(), u, nil, f, int64(len(content)), 2)
+	require.NoError(t, err)
+	_, err = io.ReadAll(seg)
+	assert.Error(t, err)
+	assert.NoError(t, seg.Close())
+}
+
+func TestProbeRanges_NotSupported(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	_, ok, err := probeRanges(context.Background(), ts.Client(), u, nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSegmented_RecordReplay(t *testing.T) {
+	content := make([]byte, 10_000)
+	rand.New(rand.NewSource(1)).Read(content)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	cassette := filepath.Join(dir, "cassette.json")
+	newConfig := func(output string) *Config {
+		return &Config{
+			DownloadURL: u,
+			Output:      filepath.Join(dir, output),
+			Segments:    3,
+			Progress:    progressNone,
+		}
+	}
+
+	cfg := newConfig("recorded")
+	cfg.RecordFile = cassette
+	_, err = download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Recorder.Save())
+
+	ts.Close()
+	cfg = newConfig("replayed")
+	cfg.Replayer, err = roundtripper.NewReplayer(cassette, replayHeaders...)
+	require.NoError(t, err)
+	_, err = download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(cfg.Output)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
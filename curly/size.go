@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sizePresets are chunk sizes of common media.
+var sizePresets = map[string]int64{
+	"floppy": floppySize,
+	// 650 MiB CD-ROM
+	"cd650": 650 << 20,
+	// single layer DVD±R
+	"dvd": 4_700_372_992,
+	// FAT32 does not allow files of 4 GiB and larger
+	"fat32-max": 4<<30 - 1,
+	// 25 MB attachment limit is applied to base64 encoded message, base64
+	// encoding makes content 4/3 larger
+	"email-25mb": 25_000_000 / 4 * 3,
+}
+
+// sizeUnits are suffixes of parseSize. Single letter units are binary
+// same as in curl, e.g. 2M is 2 MiB.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// parseSize parses positive size in bytes with optional unit suffix, e.g. "500KiB",
+// "100MB", "1.5G" or name of media preset, e.g. "floppy".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if size, ok := sizePresets[strings.ToLower(s)]; ok {
+		return size, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q, presets: %s", s, s[i:], strings.Join(sizePresetNames(), ","))
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	size := v * float64(mult)
+	// float64(math.MaxInt64) is 2^63, which overflows int64
+	switch {
+	case size < 1:
+		return 0, fmt.Errorf("invalid size %q: size must be positive", s)
+	case size >= math.MaxInt64:
+		return 0, fmt.Errorf("invalid size %q: size must be less than %d", s, int64(math.MaxInt64))
+	}
+	return int64(size), nil
+}
+
+func sizePresetNames() []string {
+	names := make([]string, 0, len(sizePresets))
+	for name := range sizePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "10B", want: 10},
+		{input: "500KiB", want: 500 * 1024},
+		{input: "500kb", want: 500 * 1000},
+		{input: "100MB", want: 100 * 1000 * 1000},
+		{input: "2M", want: 2 * 1024 * 1024},
+		{input: "1.5 GiB", want: 1536 * 1024 * 1024},
+		{input: "4GiB", want: 4 * 1024 * 1024 * 1024},
+		{input: "floppy", want: 1_474_560},
+		{input: "CD650", want: 681_574_400},
+		{input: "dvd", want: 4_700_372_992},
+		{input: "fat32-max", want: 4_294_967_295},
+		{input: "email-25mb", want: 18_750_000},
+		{input: "0", wantErr: true},
+		{input: "-1MB", wantErr: true},
+		{input: "0.1", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "MB", wantErr: true},
+		{input: "10XB", wantErr: true},
+		{input: "floppies", wantErr: true},
+		{input: "8388607T", want: 8388607 << 40},
+		{input: "8388608T", wantErr: true},
+		{input: "9223372036854775807", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const pinnedPubKeyPrefix = "sha256//"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsFlags are flags of TLS verification and client authentication.
+type tlsFlags struct {
+	caCert       string
+	caPath       string
+	cert         string
+	key          string
+	insecure     bool
+	minVersion   string
+	pinnedPubKey string
+}
+
+func (f *tlsFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.caCert, "cacert", "", "FILE with PEM certificates of trusted CAs, replaces system CAs")
+	fs.StringVar(&f.caPath, "capath", "", "DIR with PEM certificates of trusted CAs, replaces system CAs")
+	fs.StringVar(&f.cert, "cert", "", "FILE with PEM client certificate, it may contain private key as well")
+	fs.StringVar(&f.key, "key", "", "FILE with PEM private key of -cert")
+	fs.BoolVar(&f.insecure, "insecure", false, "skip verification of server certificate, -pinnedpubkey is still verified")
+	fs.StringVar(&f.minVersion, "tls-min-version", "", "minimal TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fs.StringVar(&f.pinnedPubKey, "pinnedpubkey", "", "sha256//BASE64 hashes of server public key separated by ;, connection fails if none matches")
+}
+
+// config returns TLS config specified by flags, nil config is returned when
+// defaults are used.
+func (f *tlsFlags) config() (*tls.Config, error) {
+	if *f == (tlsFlags{}) {
+		return nil, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: f.insecure}
+
+	if f.caCert != "" || f.caPath != "" {
+		pool, err := loadCertPool(f.caCert, f.caPath)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+
+	switch {
+	case f.cert != "":
+		key := f.key
+		if key == "" {
+			key = f.cert
+		}
+		cert, err := tls.LoadX509KeyPair(f.cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	case f.key != "":
+		return nil, fmt.Errorf("-key requires -cert")
+	}
+
+	if f.minVersion != "" {
+		v, ok := tlsVersions[f.minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported -tls-min-version %q, supported: 1.0, 1.1, 1.2, 1.3", f.minVersion)
+		}
+		c.MinVersion = v
+	}
+
+	if f.pinnedPubKey != "" {
+		pins, err := parsePinnedPubKey(f.pinnedPubKey)
+		if err != nil {
+			return nil, err
+		}
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinnedPubKey(cs, pins)
+		}
+	}
+	return c, nil
+}
+
+// loadCertPool loads PEM certificates of file and all files of dir.
+func loadCertPool(file, dir string) (*x509.CertPool, error) {
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	}
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read -capath: %w", err)
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	var loaded bool
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificates: %w", err)
+		}
+		// files of -capath which are not certificates are skipped
+		if pool.AppendCertsFromPEM(b) {
+			loaded = true
+		} else if name == file {
+			return nil, fmt.Errorf("no PEM certificate found in %s", name)
+		}
+	}
+	if !loaded {
+		return nil, fmt.Errorf("no PEM certificate found in %s", dir)
+	}
+	return pool, nil
+}
+
+// parsePinnedPubKey parses sha256//BASE64 hashes separated by ;.
+func parsePinnedPubKey(s string) ([][]byte, error) {
+	var pins [][]byte
+	for _, pin := range strings.Split(s, ";") {
+		pin = strings.TrimSpace(pin)
+		if !strings.HasPrefix(pin, pinnedPubKeyPrefix) {
+			return nil, fmt.Errorf("pinned public key %q must be in format %sBASE64", pin, pinnedPubKeyPrefix)
+		}
+		b, err := base64.StdEncoding.DecodeString(pin[len(pinnedPubKeyPrefix):])
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("pinned public key %q is not base64 encoded sha256 hash", pin)
+		}
+		pins = append(pins, b)
+	}
+	return pins, nil
+}
+
+// verifyPinnedPubKey checks that sha256 hash of server public key is one of
+// pins.
+func verifyPinnedPubKey(cs tls.ConnectionState, pins [][]byte) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server has no certificate to match pinned public key")
+	}
+	sum := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(pin, sum[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("public key %s%s of %s does not match -pinnedpubkey",
+		pinnedPubKeyPrefix, base64.StdEncoding.EncodeToString(sum[:]), cs.ServerName)
+}
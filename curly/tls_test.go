@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// newTestCert returns certificate signed by parent, or self-signed CA
+// certificate when parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{
+		cert: cert,
+		key:  key,
+		tls:  tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// writePEM writes certificate and optionally its key into dir/name.
+func (c *testCert) writePEM(t *testing.T, dir, name string, withKey bool) string {
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	if withKey {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		require.NoError(t, err)
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})...)
+	}
+	fname := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(fname, b, 0o600))
+	return fname
+}
+
+func (c *testCert) pin() string {
+	sum := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return pinnedPubKeyPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestTLSFlags(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+	other := newTestCert(t, "other", nil)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.tls},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MaxVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caFile := ca.writePEM(t, dir, "ca.pem", false)
+	caDir := filepath.Join(dir, "certs")
+	require.NoError(t, os.Mkdir(caDir, 0o755))
+	ca.writePEM(t, caDir, "ca.crt", false)
+	require.NoError(t, os.WriteFile(filepath.Join(caDir, "README"), []byte("not a certificate"), 0o644))
+	clientFile := client.writePEM(t, dir, "client.pem", true)
+	otherFile := other.writePEM(t, dir, "other.pem", false)
+
+	tests := []struct {
+		name    string
+		flags   tlsFlags
+		wantErr bool
+	}{
+		{name: "cacert", flags: tlsFlags{caCert: caFile, cert: clientFile}},
+		{name: "capath", flags: tlsFlags{caPath: caDir, cert: clientFile}},
+		{name: "no client certificate", flags: tlsFlags{caCert: caFile}, wantErr: true},
+		{name: "unknown CA", flags: tlsFlags{caCert: otherFile, cert: clientFile}, wantErr: true},
+		{name: "insecure", flags: tlsFlags{insecure: true, cert: clientFile}},
+		{name: "pinned", flags: tlsFlags{insecure: true, cert: clientFile, pinnedPubKey: other.pin() + ";" + server.pin()}},
+		{name: "pinned mismatch", flags: tlsFlags{caCert: caFile, cert: clientFile, pinnedPubKey: other.pin()}, wantErr: true},
+		{name: "min version", flags: tlsFlags{caCert: caFile, cert: clientFile, minVersion: "1.3"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := tt.flags.config()
+			require.NoError(t, err)
+			c := newClient(&Config{TLS: tlsConfig}, zap.NewNop().Sugar())
+			resp, err := c.Get(ts.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "hello client", string(b))
+		})
+	}
+}
+
+func TestTLSFlags_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("garbage"), 0o644))
+
+	tests := []tlsFlags{
+		{caCert: notPEM},
+		{caPath: dir},
+		{caCert: filepath.Join(dir, "missing.pem")},
+		{key: notPEM},
+		{cert: notPEM},
+		{minVersion: "1.4"},
+		{pinnedPubKey: "sha1//abc"},
+		{pinnedPubKey: "sha256//aGVsbG8="},
+	}
+	for _, f := range tests {
+		_, err := f.config()
+		assert.Error(t, err, "%+v", f)
+	}
+
+	c, err := (&tlsFlags{}).config()
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
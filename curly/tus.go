@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request"
+	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
+)
+
+// tusUpload sends gzipped, optionally encrypted, download to tus endpoint
+// cfg.UploadURL.
+type tusUpload struct {
+	u    *request.TusUploader
+	name string
+	gz   io.Closer
+	body io.Reader
+}
+
+// newTusUpload prepares upload of r, content is read by Upload.
+func newTusUpload(c *http.Client, cfg *Config, r io.Reader, p *progress) (*tusUpload, error) {
+	u, err := request.NewTusUploader(c, cfg.UploadURL.String())
+	if err != nil {
+		return nil, err
+	}
+	gz, body := uploadBody(r, cfg, p)
+	return &tusUpload{u: u, name: uploadName(cfg), gz: gz, body: body}, nil
+}
+
+// Upload sends the content, size of gzipped content is deferred until it is
+// read. It returns URL of the upload.
+func (t *tusUpload) Upload(ctx context.Context) (string, error) {
+	defer t.gz.Close()
+	// failed chunks are resumed by uploader, -retry would multiply its retries
+	return t.u.Upload(roundtripper.WithoutRetry(ctx), t.name, t.body, -1)
+}
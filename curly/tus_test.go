@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request/tustest"
+)
+
+func TestDownload_UploadTus(t *testing.T) {
+	content := strings.Repeat("tus content ", 10000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer ts.Close()
+	us := tustest.NewServer()
+	defer us.Close()
+	// the first PATCH is dropped after 100 bytes and resumed
+	dropped := false
+	us.DropPatch(func(*tustest.Upload, int64) int {
+		if dropped {
+			return -1
+		}
+		dropped = true
+		return 100
+	})
+
+	downloadURL, err := url.Parse(ts.URL + "/file.txt")
+	require.NoError(t, err)
+	uploadURL, err := url.Parse(us.Endpoint())
+	require.NoError(t, err)
+	cfg := &Config{
+		DownloadURL:    downloadURL,
+		Upload:         true,
+		UploadURL:      uploadURL,
+		UploadProtocol: uploadTus,
+		Std:            stdnull,
+		Progress:       progressNone,
+	}
+	n, err := download(zap.NewNop().Sugar(), ts.Client(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	assert.True(t, dropped)
+
+	uploads := us.Uploads()
+	require.Len(t, uploads, 1)
+	assert.Equal(t, "file.txt.gz", uploads[0].Metadata["filename"])
+	assert.Equal(t, int64(len(uploads[0].Data)), uploads[0].Length)
+	zr, err := gzip.NewReader(bytes.NewReader(uploads[0].Data))
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+}
+
+func TestDownload_UploadTusSlow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "slow upload")
+	}))
+	defer ts.Close()
+	us := tustest.NewServer()
+	defer us.Close()
+	// upload is resumed after -max-time of the download has passed
+	us.DelayPatch(300 * time.Millisecond)
+	dropped := false
+	us.DropPatch(func(*tustest.Upload, int64) int {
+		if dropped {
+			return -1
+		}
+		dropped = true
+		return 0
+	})
+
+	downloadURL, err := url.Parse(ts.URL + "/file.txt")
+	require.NoError(t, err)
+	uploadURL, err := url.Parse(us.Endpoint())
+	require.NoError(t, err)
+	cfg := &Config{
+		DownloadURL:    downloadURL,
+		Upload:         true,
+		UploadURL:      uploadURL,
+		UploadProtocol: uploadTus,
+		MaxTime:        200 * time.Millisecond,
+		Std:            stdnull,
+		Progress:       progressNone,
+	}
+	_, err = download(zap.NewNop().Sugar(), newClient(cfg, zap.NewNop().Sugar()), cfg)
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	uploads := us.Uploads()
+	require.Len(t, uploads, 1)
+	assert.Equal(t, int64(len(uploads[0].Data)), uploads[0].Length)
+	assert.Empty(t, us.Terminated())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/request"
+)
+
+// Protocols of -upload-protocol.
+const (
+	uploadMultipart = "multipart"
+	uploadTus       = "tus"
+)
+
+// s3Scheme is scheme of -upload=s3://bucket/key.
+const s3Scheme = "s3"
+
+// uploader sends download to upload target, content is read by Upload. It
+// returns URL of uploaded file.
+type uploader interface {
+	Upload(ctx context.Context) (string, error)
+}
+
+// uploadFlag is boolean -upload, which accepts S3 URL as well.
+type uploadFlag struct {
+	cfg *Config
+}
+
+func (f uploadFlag) String() string {
+	if f.cfg == nil {
+		return "false"
+	}
+	if f.cfg.Upload && f.cfg.UploadURL != nil && f.cfg.UploadURL.Scheme == s3Scheme {
+		return f.cfg.UploadURL.String()
+	}
+	return strconv.FormatBool(f.cfg.Upload)
+}
+
+func (f uploadFlag) Set(v string) error {
+	if b, err := strconv.ParseBool(v); err == nil {
+		f.cfg.Upload = b
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme != s3Scheme || u.Host == "" {
+		return fmt.Errorf("expected true, false or s3://bucket/key, got %q", v)
+	}
+	f.cfg.Upload = true
+	f.cfg.UploadURL = u
+	return nil
+}
+
+func (f uploadFlag) IsBoolFlag() bool {
+	return true
+}
+
+// uploadName returns name of uploaded file, content is gzipped and
+// optionally encrypted.
+func uploadName(cfg *Config) string {
+	name := path.Base(cfg.DownloadURL.Path) + ".gz"
+	if cfg.Secret != nil {
+		name += ".enc"
+	}
+	return name
+}
+
+// uploadBody returns gzipped, optionally encrypted, r and the same content
+// counted by progress p. The closer stops compression.
+func uploadBody(r io.Reader, cfg *Config, p *progress) (io.Closer, io.Reader) {
+	gz := request.GZIPReader(r, cfg.Secret)
+	if p != nil {
+		return gz, p.Uploaded(gz)
+	}
+	return gz, gz
+}
@@ -2,6 +2,7 @@ package crawler
 
 import (
 	"context"
+	"net/http"
 	"net/url"
 
 	"go.uber.org/zap"
@@ -13,8 +14,8 @@ type Collector struct {
 	log     *zap.SugaredLogger
 }
 
-func NewCollector(log *zap.SugaredLogger, c Cache) *Collector {
-	crawler := New(log, c)
+func NewCollector(log *zap.SugaredLogger, c Cache, rt http.RoundTripper) *Collector {
+	crawler := New(log, c, rt)
 	return &Collector{
 		crawler: crawler,
 		queue:   make([]string, 0),
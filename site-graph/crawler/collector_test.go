@@ -0,0 +1,41 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
+	"github.com/adamplansky/go-bridge-mentoring/site-graph/cache/cachemap"
+)
+
+func TestCollector_Work_Replay(t *testing.T) {
+	// testdata/example.com.json is synthetic cassette written by hand in
+	// format of `site-graph -record`, it is not a recording of real site;
+	// crawl is replayed without network access
+	rt, err := roundtripper.NewReplayer("testdata/example.com.json")
+	require.NoError(t, err)
+
+	collector := NewCollector(zap.NewNop().Sugar(), cachemap.New(), rt)
+	g := collector.Work(context.Background(), mustParse("https://www.example.com/"), 3)
+
+	want := Edges{
+		Node{mustParse("https://www.example.com")}: []Node{
+			{mustParse("https://blog.example.com")},
+			{mustParse("https://www.example.com")},
+			{mustParse("https://shop.example.com")},
+		},
+		Node{mustParse("https://blog.example.com")}: []Node{
+			{mustParse("https://www.example.com")},
+			{mustParse("https://docs.example.com")},
+		},
+		Node{mustParse("https://shop.example.com")}: []Node{
+			{mustParse("https://www.example.com")},
+		},
+	}
+	require.Empty(t, cmp.Diff(want, g.Edges))
+	require.Len(t, g.Nodes, 4)
+}
@@ -25,12 +25,16 @@ type Cache interface {
 }
 
 type Crawler struct {
-	log   *zap.SugaredLogger
-	cache Cache
-	Graph *Graph
+	log    *zap.SugaredLogger
+	cache  Cache
+	client *http.Client
+	Graph  *Graph
 }
 
-func New(log *zap.SugaredLogger, c Cache) *Crawler {
+// New returns crawler which downloads websites by rt, nil rt means
+// http.DefaultTransport. Crawls can be made hermetic by replaying recorded
+// responses, see roundtripper.NewReplayer.
+func New(log *zap.SugaredLogger, c Cache, rt http.RoundTripper) *Crawler {
 	graph := &Graph{
 		Nodes: make([]Node, 0),
 		Edges: make(map[Node][]Node, 0),
@@ -40,6 +44,10 @@ func New(log *zap.SugaredLogger, c Cache) *Crawler {
 	return &Crawler{
 		log:   log,
 		cache: c,
+		client: &http.Client{
+			Transport: rt,
+			Timeout:   5 * time.Second,
+		},
 		Graph: graph,
 	}
 }
@@ -140,16 +148,12 @@ func (c *Crawler) ParseWebsite(ctx context.Context, websiteURL url.URL) ([]link,
 		return links, nil
 	}
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
-
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, websiteURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := client.Do(req)
+	res, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
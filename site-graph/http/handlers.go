@@ -53,7 +53,7 @@ func (s *server) ScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collector := crawler.NewCollector(s.log, s.cache)
+	collector := crawler.NewCollector(s.log, s.cache, s.rt)
 	g := collector.Work(ctx, *params.URL, params.Depth)
 	if len(g.Nodes) == 0 {
 		w.WriteHeader(http.StatusNoContent)
@@ -25,13 +25,16 @@ type server struct {
 	log     *zap.SugaredLogger
 	crawler *crawler.Crawler
 	cache   Cache
+	// rt downloads crawled websites, nil means http.DefaultTransport
+	rt http.RoundTripper
 }
 
-func NewServer(log *zap.SugaredLogger, c Cache) *server {
+func NewServer(log *zap.SugaredLogger, c Cache, rt http.RoundTripper) *server {
 	s := server{
 		log:     log,
-		crawler: crawler.New(log, c),
+		crawler: crawler.New(log, c, rt),
 		cache:   c,
+		rt:      rt,
 	}
 	s.router = s.routes()
 	return &s
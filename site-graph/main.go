@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	nethttp "net/http"
 	"os"
 	"os/signal"
 
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
+	"github.com/adamplansky/go-bridge-mentoring/curly/roundtripper"
 	"github.com/adamplansky/go-bridge-mentoring/site-graph/cache/cachemap"
 	"github.com/adamplansky/go-bridge-mentoring/site-graph/http"
 )
@@ -17,13 +21,39 @@ func main() {
 	log := logger.Sugar()
 	defer logger.Sync() // flushes buffer, if any
 
+	record := flag.String("record", "", "FILE where crawled websites are recorded for -replay")
+	replay := flag.String("replay", "", "FILE with websites recorded by -record which are crawled instead of network")
+	flag.Parse()
+	if *record != "" && *replay != "" {
+		log.Fatal("-record can not be combined with -replay")
+	}
+
+	var rt nethttp.RoundTripper
+	var recorder *roundtripper.Recorder
+	if *replay != "" {
+		replayer, err := roundtripper.NewReplayer(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rt = replayer
+	}
+	if *record != "" {
+		recorder = roundtripper.NewRecorder(nethttp.DefaultTransport, *record)
+		rt = recorder
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
 	cache := cachemap.New()
-	server := http.NewServer(log, cache)
+	server := http.NewServer(log, cache, rt)
 	fmt.Println("I'm alive :8080")
-	if err := server.Run(ctx, ":8080"); err != nil {
+	err := server.Run(ctx, ":8080")
+	// crawl recorded so far is saved even if the server failed
+	if recorder != nil {
+		err = multierr.Append(err, recorder.Save())
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }